@@ -0,0 +1,83 @@
+package querydb
+
+import (
+	"reflect"
+)
+
+//Codec 自定义类型的编解码，如 UUID 存储为 BINARY(16)、枚举存储为 TINYINT
+type Codec struct {
+	Encode func(value interface{}) (interface{}, error) //Go 值转换为写入数据库的值
+	Decode func(src interface{}) (interface{}, error)   //数据库的值转换为 Go 值，src 不为 nil，[]byte 在下一次扫描后失效，需要保留时应复制
+}
+
+//RegisterCodec 注册类型的编解码，sample 为该类型的值，如 RegisterCodec(uuid.UUID{}, codec)
+//写入的数据、查询条件的参数按 Encode 转换，ToStruct/Scan 到该类型时按 Decode 转换，同样适用于该类型的指针
+func (configs *Configs) RegisterCodec(sample interface{}, codec Codec) *Configs {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if configs.codecs == nil {
+		configs.codecs = make(map[reflect.Type]Codec)
+	}
+	configs.codecs[reflect.TypeOf(sample)] = codec
+	return configs
+}
+
+//codec 获取类型的编解码，elem 表示注册的是指针指向的类型
+func (configs *Configs) codec(typ reflect.Type) (codec Codec, elem bool, ok bool) {
+	if configs == nil || typ == nil {
+		return
+	}
+	configs.mu.RLock()
+	defer configs.mu.RUnlock()
+	if codec, ok = configs.codecs[typ]; ok {
+		return
+	}
+	if typ.Kind() == reflect.Ptr {
+		codec, ok = configs.codecs[typ.Elem()]
+		elem = ok
+	}
+	return
+}
+
+//encode 按注册的编解码转换写入的值，nil 指针转换为 nil
+func (configs *Configs) encode(value interface{}) (interface{}, error) {
+	codec, elem, ok := configs.codec(reflect.TypeOf(value))
+	if !ok || codec.Encode == nil {
+		return value, nil
+	}
+	if elem {
+		v := reflect.ValueOf(value)
+		if v.IsNil() {
+			return nil, nil
+		}
+		value = v.Elem().Interface()
+	}
+	return codec.Encode(value)
+}
+
+//codecScanner 按注册的编解码扫描到成员
+type codecScanner struct {
+	value reflect.Value
+	codec Codec
+}
+
+//Scan 实现 sql.Scanner
+func (s *codecScanner) Scan(src interface{}) error {
+	if src == nil {
+		s.value.Set(reflect.Zero(s.value.Type()))
+		return nil
+	}
+	v, err := s.codec.Decode(src)
+	if err != nil {
+		return err
+	}
+	return convertAssign(s.value, v)
+}
+
+//scanDest 获取扫描的目标，value 的类型注册了 Decode 时使用 codecScanner
+func (opts decodeOptions) scanDest(value reflect.Value) interface{} {
+	if codec, _, ok := opts.configs.codec(value.Type()); ok && codec.Decode != nil {
+		return &codecScanner{value: value, codec: codec}
+	}
+	return value.Addr().Interface()
+}
@@ -0,0 +1,62 @@
+package querydb
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type celsius float64
+
+type fahrenheit float64
+
+//chainedCodecs celsius 编码为 fahrenheit，fahrenheit 编码为字符串，重复编码时参数会变为字符串
+func chainedCodecs(db *QueryDb) {
+	db.configs.RegisterCodec(celsius(0), Codec{Encode: func(value interface{}) (interface{}, error) {
+		return fahrenheit(float64(value.(celsius))*9/5 + 32), nil
+	}})
+	db.configs.RegisterCodec(fahrenheit(0), Codec{Encode: func(value interface{}) (interface{}, error) {
+		return strconv.FormatFloat(float64(value.(fahrenheit)), 'f', -1, 64) + "F", nil
+	}})
+}
+
+func TestCodecEncodesInsertOnce(t *testing.T) {
+	db, server := newFakeDb(t)
+	chainedCodecs(db)
+
+	if _, err := db.NewQuery().Table("weather").Insert(map[string]interface{}{"temp": celsius(100)}); err != nil {
+		t.Fatal(err)
+	}
+	calls := server.executed()
+	if len(calls) != 1 {
+		t.Fatalf("executed %v", calls)
+	}
+	if want := []driver.Value{float64(212)}; !reflect.DeepEqual(calls[0].args, want) {
+		t.Fatalf("args = %#v, want %#v", calls[0].args, want)
+	}
+
+	got := db.NewQuery().Table("weather").InsertSQL(map[string]interface{}{"temp": celsius(100)})
+	if want := "INSERT INTO weather  (temp) VALUES (212)"; got != want {
+		t.Fatalf("InsertSQL = %q, want %q", got, want)
+	}
+}
+
+func TestCodecEncodesWhereOnce(t *testing.T) {
+	db, server := newFakeDb(t)
+	chainedCodecs(db)
+
+	if _, err := db.NewQuery().Table("weather").Where("temp", celsius(0)).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	calls := server.executed()
+	if len(calls) != 1 {
+		t.Fatalf("executed %v", calls)
+	}
+	if want := []driver.Value{float64(32)}; !reflect.DeepEqual(calls[0].args, want) {
+		t.Fatalf("args = %#v, want %#v", calls[0].args, want)
+	}
+	if got, want := db.GetLastSql().ToString(), "DELETE  FROM weather WHERE temp = 32"; got != want {
+		t.Fatalf("ToString = %q, want %q", got, want)
+	}
+}
@@ -2,8 +2,10 @@ package querydb
 
 import (
 	"database/sql"
-	"math/rand"
-	"strconv"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,7 +25,48 @@ type Config struct {
 	MaxIdleConns int           //设置闲置的连接数,连接池里面允许Idel的最大连接数, 这些Idel的连接 就是并发时可以同时获取的连接,也是用完后放回池里面的互用的连接, 从而提升性能
 	Debug        bool
 	MaxOpenConns int       //设置最大打开的连接数，默认值为0表示不限制。控制应用于数据库建立连接的数量，避免过多连接压垮数据库。
-	Slave        []*Config //从库
+	Slave        []*Config //从库，未设置的账号及密码、数据库、字符编码、时区、超时、TLS 等连接参数沿用主库
+
+	Unsafe          bool  //关闭安全模式，默认开启，开启时不带条件的 Update/Delete 会返回 SafeError
+	MaxAffectedRows int64 //Update/Delete 允许影响的最大行数，超出后在事务内回滚，0 表示不限制
+
+	SoftDeletes map[string]string //软删除表，表名 => 软删除字段，如 {"user": "deleted_at"}
+
+	Decimal  DecimalMode  //ToInterface 中 DECIMAL 的解码方式，默认字符串
+	Unsigned UnsignedMode //ToInterface 中 BIGINT UNSIGNED 的解码方式，默认 uint64
+	NullAs   string       //ToMap/ToArray 中 NULL 的占位字符串，默认空字符串
+
+	Strict bool //严格映射，ToStruct/Scan 时查询字段没有对应成员或成员没有对应字段返回错误
+
+	Lazy          bool          //延迟连接，获取连接时不检查，首次执行语句时才建立连接
+	Retries       int           //连接失败的重试次数，默认不重试
+	RetryInterval time.Duration //首次重试的等待时间，之后每次翻倍，默认 100ms
+
+	Weight              int           //从库权重，用于 WeightedBalancer，默认 1
+	Balancer            Balancer      //从库负载均衡，默认随机
+	HealthCheckInterval time.Duration //定期 Ping 从库的间隔，0 表示不检查，只根据执行语句的错误判断
+	MaxFailures         int           //从库连续失败多少次后剔除，默认 3
+	EjectBackoff        time.Duration //从库剔除的时间，连续剔除时翻倍，最多 64 倍，默认 1s
+	NoMasterFallback    bool          //从库都不可用时返回 ErrNoReplica，默认使用主库
+
+	MaxLag           time.Duration //从库复制延迟超过该值时不使用，0 表示不检查
+	LagQuery         string        //获取延迟秒数的语句，如心跳表，默认使用 SHOW REPLICA STATUS 的 Seconds_Behind_Source
+	LagCheckInterval time.Duration //检查复制延迟的间隔，默认 1s
+	ReadYourWrites   time.Duration //WithSession 的会话中写入后，该时间内 ReadContext 返回主库
+
+	Loc               *time.Location    //时区，默认 time.Local
+	Timeout           time.Duration     //建立连接的超时时间
+	ReadTimeout       time.Duration     //读超时
+	WriteTimeout      time.Duration     //写超时
+	ParseTime         bool              //DATE、DATETIME 返回 time.Time
+	Collation         string            //连接的排序规则，如 utf8mb4_general_ci
+	InterpolateParams bool              //在客户端替换占位符，减少一次预处理的往返
+	Params            map[string]string //其它连接参数，如 {"sql_mode": "'STRICT_ALL_TABLES'"}
+	TLS               string            //TLS 模式：true、false、skip-verify、preferred 或 mysql.RegisterTLSConfig 注册的名称
+	TLSCAFile         string            //CA 证书文件
+	TLSCertFile       string            //客户端证书文件
+	TLSKeyFile        string            //客户端私钥文件
+	TLSServerName     string            //校验证书的服务器名称，默认 Host
 }
 
 //SetSlave 设置 Slave
@@ -39,110 +82,294 @@ func (config *Config) SetSlave(c *Config) *Config {
 type Configs struct {
 	cfg         map[string]*Config
 	connections map[string]*QueryDb
+	connecting  map[string]*connectCall  //正在建立的连接，同一个连接只建立一次
+	pools       map[string]*replicaPool  //从库集合
+	scopes      map[string][]globalScope //表名 => 全局作用域
+	tenancy     tenancy                  //多租户配置
+	codecs      map[reflect.Type]Codec   //自定义类型的编解码
+	closed      bool                     //已调用 Close 或 Shutdown
+	done        chan struct{}            //Close 或 Shutdown 时关闭，用于停止 Watch
+	fileConfigs map[string]bool          //从文件加载的配置名称，重新加载时只移除其中的配置
+	env         []envLayer               //LoadEnv 解析的环境变量，重新加载时按顺序再次应用
 	mu          sync.RWMutex
 }
 
+//ErrConfigNotFound 找不到数据库配置
+var ErrConfigNotFound = errors.New("db config not found")
+
 //Default ..
 func Default() *Configs {
 	return &Configs{
 		cfg:         make(map[string]*Config),
 		connections: make(map[string]*QueryDb),
+		connecting:  make(map[string]*connectCall),
 	}
 }
 
-//SetConfig 设置配置文件
+//SetConfig 设置配置文件，运行时替换已有的配置时，关闭按原配置建立的连接，租户路由生成的配置按新配置重新生成
 func (configs *Configs) SetConfig(name string, cf *Config) *Configs {
+	configs.mu.Lock()
+	old, ok := configs.cfg[name]
 	configs.cfg[name] = cf
+	var closed []*QueryDb
+	if ok && old != cf {
+		configs.dropTenantConfigs(name)
+		closed = configs.dropConnections(name)
+	}
+	configs.mu.Unlock()
+	drainConnections(closed)
 	return configs
 }
 
-//URI 构造数据库连接
-func (config *Config) URI() string {
-	return config.Username + ":" +
-		config.Password + "@tcp(" +
-		config.Host + ":" +
-		config.Port + ")/" +
-		config.Database + "?charset=" +
-		config.Charset + "&loc=" + time.Local.String()
+//RemoveConfig 移除配置并关闭相关的连接，包括从库及租户路由生成的连接
+func (configs *Configs) RemoveConfig(name string) error {
+	configs.mu.Lock()
+	if _, ok := configs.cfg[name]; !ok {
+		configs.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrConfigNotFound, name)
+	}
+	delete(configs.cfg, name)
+	configs.dropTenantConfigs(name)
+	closed := configs.dropConnections(name)
+	configs.mu.Unlock()
+	drainConnections(closed)
+	return nil
 }
 
-//random 随机数
-func random(max int) int {
-	if max < 1 {
-		return 0
+//dropTenantConfigs 移除租户路由按配置生成的 name@database 配置，需持有写锁
+func (configs *Configs) dropTenantConfigs(name string) {
+	for key := range configs.cfg {
+		if strings.HasPrefix(key, name+"@") {
+			delete(configs.cfg, key)
+		}
 	}
-	rand.Seed(time.Now().Unix())
-	return rand.Intn(max)
 }
 
-//Write 获取一个
-func (configs *Configs) Write(name string) *QueryDb {
+//dropConnections 移除配置对应的连接及正在建立的连接，需持有写锁，返回的连接在释放锁后关闭
+func (configs *Configs) dropConnections(name string) (closed []*QueryDb) {
+	owned := func(key string) bool {
+		return key == name || strings.HasPrefix(key, name+"_read_") || strings.HasPrefix(key, name+"@")
+	}
+	for key, conn := range configs.connections {
+		if owned(key) {
+			closed = append(closed, conn)
+			delete(configs.connections, key)
+		}
+	}
+	for key := range configs.connecting {
+		if owned(key) {
+			delete(configs.connecting, key)
+		}
+	}
+	for key, pool := range configs.pools {
+		if owned(key) {
+			pool.close()
+			delete(configs.pools, key)
+		}
+	}
+	return closed
+}
 
-	conn, ok := configs.connections[name]
-	if ok {
-		return conn
+//drainConnections 在后台等待连接上执行中的语句及事务完成后关闭连接
+func drainConnections(conns []*QueryDb) {
+	for _, conn := range conns {
+		go func(conn *QueryDb) {
+			<-conn.active.retire()
+			if err := conn.db.Close(); err != nil {
+				Log.Warn(err.Error())
+			}
+		}(conn)
 	}
-	config, ok := configs.cfg[name]
-	if !ok {
-		Log.Panic("DB配置:" + name + "找不到！")
+}
+
+//URI 构造数据库连接，出错时返回空字符串，需要错误信息时使用 FormatDSN
+func (config *Config) URI() string {
+	dsn, err := config.FormatDSN()
+	if err != nil {
+		Log.Error(err.Error())
 	}
-	//获取主
-	db := connect(config)
+	return dsn
+}
 
-	configs.mu.Lock()
-	configs.connections[name] = &QueryDb{db: db, link: config}
-	configs.mu.Unlock()
+//Write 获取主库连接，找不到配置或连接失败时 panic，需要处理错误时使用 WriteE
+func (configs *Configs) Write(name string) *QueryDb {
+	return mustConnect(configs.WriteE(name))
+}
 
+//WriteE 获取主库连接，找不到配置时返回 ErrConfigNotFound，已关闭时返回 ErrClosed
+func (configs *Configs) WriteE(name string) (*QueryDb, error) {
 	configs.mu.RLock()
-	v := configs.connections[name]
+	config, ok := configs.cfg[name]
+	closed := configs.closed
 	configs.mu.RUnlock()
-	return v
+	if closed {
+		return nil, ErrClosed
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, name)
+	}
+	//获取主
+	return configs.connection(name, config)
 }
 
+//Read 获取从库连接，没有从库时使用主库，找不到配置或连接失败时 panic，需要处理错误时使用 ReadE
 func (configs *Configs) Read(name string) *QueryDb {
+	return mustConnect(configs.ReadE(name))
+}
+
+//mustConnect 获取连接出错时记录日志并 panic
+func mustConnect(db *QueryDb, err error) *QueryDb {
+	if err != nil {
+		Log.Error(err.Error())
+		panic(err)
+	}
+	return db
+}
+
+//ReadE 获取从库连接，找不到配置时返回 ErrConfigNotFound，已关闭时返回 ErrClosed
+func (configs *Configs) ReadE(name string) (*QueryDb, error) {
+	configs.mu.RLock()
 	config, ok := configs.cfg[name]
+	closed := configs.closed
+	configs.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
 	if !ok {
-		Log.Panic("DB配置:" + name + "找不到！")
+		return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, name)
+	}
+	if len(config.Slave) > 0 {
+		return configs.readReplica(name, config)
 	}
-	keyname := name
-	readlen := len(config.Slave)
+	return configs.connection(name, config)
+}
+
+//connectCall 正在建立的连接
+type connectCall struct {
+	done  chan struct{}
+	conn  *QueryDb
+	err   error
+	retry *Config //建立连接期间配置被替换时的当前配置，需按该配置重新建立
+}
 
-	readnum := 0
-	if readlen > 0 {
-		readnum = random(readlen)
-		keyname += "_read_" + strconv.Itoa(readnum)
-		config = configs.cfg[name].Slave[readnum]
+//connection 获取 key 对应的连接，不存在时建立，并发获取同一个 key 时只建立一次连接
+//建立连接期间配置被替换时，按当前配置重新建立
+func (configs *Configs) connection(key string, config *Config) (*QueryDb, error) {
+	for {
+		call := configs.connectOnce(key, config)
+		if call.retry == nil {
+			return call.conn, call.err
+		}
+		config = call.retry
 	}
+}
 
-	conn, ok := configs.connections[keyname]
+//connectOnce 按 config 建立连接，已有连接或正在建立时复用
+func (configs *Configs) connectOnce(key string, config *Config) *connectCall {
+	//已建立的连接只需读锁
+	configs.mu.RLock()
+	conn, ok := configs.connections[key]
+	closed := configs.closed
+	configs.mu.RUnlock()
+	if closed {
+		return &connectCall{err: ErrClosed}
+	}
 	if ok {
-		return conn
+		return &connectCall{conn: conn}
 	}
 
-	db := connect(config)
 	configs.mu.Lock()
-	configs.connections[keyname] = &QueryDb{db: db, link: config}
+	if configs.closed {
+		configs.mu.Unlock()
+		return &connectCall{err: ErrClosed}
+	}
+	if current, ok := configs.cfg[key]; ok && current != config {
+		//获取配置后、建立连接前配置被替换
+		config = current
+	}
+	if conn, ok := configs.connections[key]; ok {
+		configs.mu.Unlock()
+		return &connectCall{conn: conn}
+	}
+	if call, ok := configs.connecting[key]; ok {
+		configs.mu.Unlock()
+		<-call.done
+		return call
+	}
+	if configs.connections == nil {
+		configs.connections = make(map[string]*QueryDb)
+	}
+	if configs.connecting == nil {
+		configs.connecting = make(map[string]*connectCall)
+	}
+	call := &connectCall{done: make(chan struct{})}
+	configs.connecting[key] = call
 	configs.mu.Unlock()
 
-	configs.mu.RLock()
-	v := configs.connections[keyname]
-	configs.mu.RUnlock()
-	return v
+	db, err := connect(config)
+
+	configs.mu.Lock()
+	switch {
+	case configs.closed:
+		call.err = ErrClosed
+	case configs.connecting[key] != call:
+		//建立连接期间配置被移除或替换，替换时按当前配置重新建立
+		if current, ok := configs.cfg[key]; ok {
+			call.retry = current
+		} else {
+			call.err = fmt.Errorf("%w: %s was removed while connecting", ErrConfigNotFound, key)
+		}
+	case err != nil:
+		call.err = err
+	default:
+		call.conn = &QueryDb{db: db, name: key, link: config, configs: configs, active: &activity{}}
+		configs.connections[key] = call.conn
+	}
+	if call.conn == nil && db != nil {
+		db.Close()
+	}
+	if configs.connecting[key] == call {
+		delete(configs.connecting, key)
+	}
+	configs.mu.Unlock()
+	close(call.done)
+	return call
 }
 
-//connect 数据库连接
-func connect(config *Config) *sql.DB {
+//connect 数据库连接，Lazy 时不检查连接，否则 Ping 失败后按 RetryInterval 翻倍重试 Retries 次
+func connect(config *Config) (*sql.DB, error) {
 	//数据库连接
-	db, err := sql.Open("mysql", config.URI())
+	dsn, err := config.FormatDSN()
 	if err != nil {
-		Log.Fatal(err.Error())
+		return nil, err
 	}
-	if err = db.Ping(); err != nil {
-		Log.Fatal(err.Error())
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
 	}
 	db.SetMaxOpenConns(config.MaxOpenConns)
 	db.SetMaxIdleConns(config.MaxIdleConns)
 	db.SetConnMaxLifetime(config.MaxLifetime)
 	db.SetConnMaxIdleTime(config.MaxIdleTime)
-	return db
+	if config.Lazy {
+		return db, nil
+	}
+
+	backoff := config.RetryInterval
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	for i := 0; ; i++ {
+		if err = db.Ping(); err == nil {
+			return db, nil
+		}
+		if i >= config.Retries {
+			break
+		}
+		Log.Warn(fmt.Sprintf("connect %s:%s failed, retry in %s: %v", config.Host, config.Port, backoff, err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	db.Close()
+	return nil, fmt.Errorf("connect %s:%s/%s: %w", config.Host, config.Port, config.Database, err)
 }
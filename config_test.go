@@ -0,0 +1,131 @@
+package querydb
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConfigsConcurrentAccess(t *testing.T) {
+	configs := Default()
+	defer configs.Close()
+	newConfig := func(database string) *Config {
+		master := &Config{Host: "127.0.0.1", Port: "1", Database: database, Lazy: true}
+		master.SetSlave(&Config{Host: "127.0.0.1", Port: "2", Database: database, Lazy: true})
+		return master
+	}
+	configs.SetConfig("default", newConfig("db"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := configs.WriteE("default"); err != nil && !errors.Is(err, ErrConfigNotFound) {
+					t.Errorf("WriteE: %v", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := configs.ReadE("default"); err != nil && !errors.Is(err, ErrConfigNotFound) {
+					t.Errorf("ReadE: %v", err)
+				}
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				configs.SetConfig("default", newConfig("db_"+strconv.Itoa(i)))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := configs.RemoveConfig("default"); err != nil && !errors.Is(err, ErrConfigNotFound) {
+					t.Errorf("RemoveConfig: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConnectionRetriesReplacedConfig(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan struct{}, 1)
+	release := make(chan struct{})
+	go func() {
+		//接受连接但不响应握手，直到 release 后断开，使 Ping 阻塞后失败
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case accepted <- struct{}{}:
+			default:
+			}
+			go func() {
+				<-release
+				conn.Close()
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	configs := Default()
+	defer configs.Close()
+	configs.SetConfig("default", &Config{Host: host, Port: port})
+
+	type result struct {
+		db  *QueryDb
+		err error
+	}
+	results := make(chan result, 4)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			db, err := configs.WriteE("default")
+			results <- result{db, err}
+		}()
+	}
+	<-accepted
+	replacement := &Config{Host: host, Port: port, Lazy: true}
+	configs.SetConfig("default", replacement)
+	close(release)
+
+	for i := 0; i < cap(results); i++ {
+		res := <-results
+		if res.err != nil {
+			t.Fatalf("WriteE err = %v", res.err)
+		}
+		if res.db.link != replacement {
+			t.Fatalf("connected with %+v, want the replacement config", res.db.link)
+		}
+	}
+}
+
+func BenchmarkWriteECached(b *testing.B) {
+	configs := Default()
+	defer configs.Close()
+	configs.SetConfig("default", &Config{Host: "127.0.0.1", Lazy: true})
+	if _, err := configs.WriteE("default"); err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := configs.WriteE("default"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
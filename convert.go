@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
-	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -56,97 +55,121 @@ func toString(src interface{}) (dst string, err error) {
 	return
 }
 
-//提取tag信息
-func extractTagInfo(st reflect.Value) (tagList map[string]reflect.Value, err error) {
-
-	stVal := reflect.Indirect(st)
-
-	if stVal.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("the variable type is %v, not a struct", stVal.Kind())
+//toNullString 转换成 *string，NULL 返回 nil
+func toNullString(src interface{}) (*string, error) {
+	if reflect.Indirect(reflect.ValueOf(src)).Interface() == nil {
+		return nil, nil
 	}
+	dst, err := toString(src)
+	if err != nil {
+		return nil, err
+	}
+	return &dst, nil
+}
 
-	tagList = make(map[string]reflect.Value)
-
-	for i := 0; i < stVal.NumField(); i++ {
-
-		//获取结构体成员
-		v := stVal.Field(i)
-
-		if v.Kind() == reflect.Ptr {
-			//如果没有初始化，则需要先初始化
-			if v.IsNil() {
-				var typ reflect.Type
-				if v.Type().Kind() == reflect.Ptr {
-					typ = v.Type().Elem()
-				} else {
-					typ = v.Type()
-				}
-				vv := reflect.New(typ)
-				v.Set(vv)
-			}
-			//如果是结构体指针，则在进行提取
-			if v.Elem().Kind() == reflect.Struct {
-				t, err := extractTagInfo(v.Elem())
-				if err != nil {
-					return nil, err
-				}
+//convertAssign 将驱动返回的值赋给结构体成员
+func convertAssign(dest reflect.Value, src interface{}) error {
+	if src == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+	if scanner, ok := dest.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+	if dest.Kind() == reflect.Ptr {
+		v := reflect.New(dest.Type().Elem())
+		if err := convertAssign(v.Elem(), src); err != nil {
+			return err
+		}
+		dest.Set(v)
+		return nil
+	}
 
-				for k, ptr := range t {
-					if _, ok := tagList[k]; ok {
-						return nil, fmt.Errorf("%s:%s is exists", "db", k)
-					}
-					tagList[k] = ptr
-				}
+	sv := reflect.ValueOf(src)
+	switch dest.Kind() {
+	case reflect.Interface:
+		if b, ok := src.([]byte); ok {
+			src = append([]byte(nil), b...)
+		}
+		dest.Set(reflect.ValueOf(src))
+		return nil
+	case reflect.String:
+		s, err := toString(&src)
+		if err != nil {
+			return err
+		}
+		dest.SetString(s)
+		return nil
+	case reflect.Slice:
+		if dest.Type().Elem().Kind() == reflect.Uint8 {
+			switch v := src.(type) {
+			case []byte:
+				dest.SetBytes(append([]byte(nil), v...))
+				return nil
+			case string:
+				dest.SetBytes([]byte(v))
+				return nil
 			}
-		} else if v.Kind() == reflect.Map && v.IsNil() {
-			//如果是map类型，并且没有初始化，则需要初始化一下
-			v.Set(reflect.MakeMap(v.Type()))
-		} else if v.Kind() == reflect.Struct {
-			var ignore bool
-			//以下的类型，会再scan的执行转换，所以不需要二次处理
-			switch v.Interface().(type) {
-			case time.Time:
-				ignore = true
-			case sql.NullTime:
-				ignore = true
-			case sql.NullString:
-				ignore = true
-			case sql.NullBool:
-				ignore = true
-			case sql.NullInt64:
-				ignore = true
-			case sql.NullInt32:
-				ignore = true
-			case sql.NullFloat64:
-				ignore = true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if b, ok := src.(bool); ok {
+			if b {
+				src = int64(1)
+			} else {
+				src = int64(0)
 			}
-
-			if !ignore {
-				t, err := extractTagInfo(v)
-				if err != nil {
-					return nil, err
-				}
-
-				for k, ptr := range t {
-					if _, ok := tagList[k]; ok {
-						return nil, fmt.Errorf("%s:%s is exists", "db", k)
-					}
-					tagList[k] = ptr
-				}
+		}
+		i, err := decodeInt(src)
+		if err != nil {
+			return err
+		}
+		dest.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := decodeUint(src)
+		if err != nil {
+			return err
+		}
+		dest.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := decodeFloat(src)
+		if err != nil {
+			return err
+		}
+		dest.SetFloat(f)
+		return nil
+	case reflect.Bool:
+		switch v := src.(type) {
+		case bool:
+			dest.SetBool(v)
+		case int64:
+			dest.SetBool(v != 0)
+		default:
+			b, err := strconv.ParseBool(decodeString(src))
+			if err != nil {
+				return err
 			}
+			dest.SetBool(b)
 		}
-		tagName := stVal.Type().Field(i).Tag.Get("db")
-		if tagName != "" {
-			//tag内容通过";"进行分割
-			attr := strings.Split(tagName, ",")
-			column := attr[0]
-			if _, ok := tagList[column]; ok {
-				return nil, fmt.Errorf("%s:%s is exists", "db", tagName)
+		return nil
+	case reflect.Struct:
+		if dest.Type() == reflect.TypeOf(time.Time{}) {
+			t, err := decodeTime(src, time.Local)
+			if err != nil {
+				return err
 			}
-			//字段对应结构体成员地址
-			tagList[column] = v
+			dest.Set(reflect.ValueOf(t))
+			return nil
 		}
 	}
-
-	return
+	if sv.Type().AssignableTo(dest.Type()) {
+		dest.Set(sv)
+		return nil
+	}
+	if sv.Type().ConvertibleTo(dest.Type()) {
+		dest.Set(sv.Convert(dest.Type()))
+		return nil
+	}
+	return fmt.Errorf("unsupported scan, storing driver.Value type %T into type %v", src, dest.Type())
 }
@@ -31,29 +31,50 @@ type Sql struct {
 // QueryDb mysql 配置
 type QueryDb struct {
 	db      *sql.DB
+	name    string //连接的名称
 	lastsql Sql
-	link    *Config
+	link    *Config //配置，从库连接为主库的配置
+	configs *Configs
+	ctx     context.Context
+	tenant  interface{} //租户
+	replica *replica    //从库，用于记录健康状态
+	active  *activity   //执行中的语句及事务，连接池替换后等待完成再关闭
 }
 
 //QueryTx
 type QueryTx struct {
 	Tx      *sql.Tx
+	name    string //主库连接的名称，从库上的事务为空
 	lastsql Sql
 	link    *Config
+	configs *Configs
+	ctx     context.Context
+	tenant  interface{}
+	active  *activity
 }
 
 //NewQuery 生成一个新的查询构造器
 func (querydb *QueryDb) NewQuery() *QueryBuilder {
-	return &QueryBuilder{connection: querydb, debug: querydb.link.Debug}
+	query := newQuery(querydb, querydb.link, querydb.configs)
+	query.tenant = querydb.tenant
+	return query
 }
 
 //Begin 开启一个事务
 func (querydb *QueryDb) Begin() (*QueryTx, error) {
-	tx, err := querydb.db.Begin()
+	if err := querydb.active.begin(); err != nil {
+		return nil, err
+	}
+	tx, err := querydb.db.BeginTx(querydb.context(), nil)
 	if err != nil {
+		querydb.active.end()
 		return nil, err
 	}
-	return &QueryTx{Tx: tx, link: querydb.link}, nil
+	querytx := &QueryTx{Tx: tx, link: querydb.link, configs: querydb.configs, ctx: querydb.ctx, tenant: querydb.tenant, active: querydb.active}
+	if querydb.replica == nil {
+		querytx.name = querydb.name
+	}
+	return querytx, nil
 }
 
 //Exec 复用执行语句
@@ -64,9 +85,17 @@ func (querydb *QueryDb) Exec(query string, args ...interface{}) (sql.Result, err
 	defer func() {
 		querydb.lastsql.CostTime = time.Since(start)
 	}()
-	ctx := context.TODO()
+	ctx := querydb.context()
 	var res sql.Result
 	var err error
+	if err = querydb.active.begin(); err != nil {
+		return res, err
+	}
+	defer querydb.active.end()
+	querydb.replica.begin()
+	defer func() {
+		querydb.replica.end(err)
+	}()
 
 	//添加预处理
 	stmt, err := querydb.db.PrepareContext(ctx, query)
@@ -78,6 +107,9 @@ func (querydb *QueryDb) Exec(query string, args ...interface{}) (sql.Result, err
 	defer stmt.Close()
 	res, err = stmt.ExecContext(ctx, args...)
 	querydb.db.PingContext(ctx)
+	if err == nil && querydb.replica == nil {
+		markWrite(querydb.ctx, querydb.link, querydb.name)
+	}
 
 	return res, err
 }
@@ -90,9 +122,17 @@ func (querydb *QueryDb) Query(query string, args ...interface{}) (*sql.Rows, err
 	defer func() {
 		querydb.lastsql.CostTime = time.Since(start)
 	}()
-	ctx := context.TODO()
+	ctx := querydb.context()
 	var res *sql.Rows
 	var err error
+	if err = querydb.active.begin(); err != nil {
+		return res, err
+	}
+	defer querydb.active.end()
+	querydb.replica.begin()
+	defer func() {
+		querydb.replica.end(err)
+	}()
 
 	//添加预处理
 	stmt, err := querydb.db.PrepareContext(ctx, query)
@@ -106,6 +146,25 @@ func (querydb *QueryDb) Query(query string, args ...interface{}) (*sql.Rows, err
 	return res, err
 }
 
+//WithContext 返回绑定 context 的连接，context 中携带的租户会一并绑定
+func (querydb *QueryDb) WithContext(ctx context.Context) *QueryDb {
+	q := *querydb
+	q.lastsql = Sql{}
+	q.ctx = ctx
+	if tenant, ok := TenantFromContext(ctx); ok {
+		q.tenant = tenant
+	}
+	return &q
+}
+
+//context 获取执行语句使用的 context
+func (querydb *QueryDb) context() context.Context {
+	if querydb.ctx != nil {
+		return querydb.ctx
+	}
+	return context.TODO()
+}
+
 //GetLastSql 获取sql语句
 func (querydb *QueryDb) GetLastSql() Sql {
 	return querydb.lastsql
@@ -113,17 +172,32 @@ func (querydb *QueryDb) GetLastSql() Sql {
 
 // Commit 事务提交
 func (querytx *QueryTx) Commit() error {
-	return querytx.Tx.Commit()
+	err := querytx.Tx.Commit()
+	querytx.finish()
+	if err == nil {
+		markWrite(querytx.ctx, querytx.link, querytx.name)
+	}
+	return err
 }
 
 // Rollback 事务回滚
 func (querytx *QueryTx) Rollback() error {
-	return querytx.Tx.Rollback()
+	err := querytx.Tx.Rollback()
+	querytx.finish()
+	return err
+}
+
+//finish 事务结束，只记录一次
+func (querytx *QueryTx) finish() {
+	querytx.active.end()
+	querytx.active = nil
 }
 
 // NewQuery 生成一个新的查询构造器
 func (querytx *QueryTx) NewQuery() *QueryBuilder {
-	return &QueryBuilder{connection: querytx}
+	query := newQuery(querytx, querytx.link, querytx.configs)
+	query.tenant = querytx.tenant
+	return query
 }
 
 //Exec 复用执行语句
@@ -135,7 +209,7 @@ func (querytx *QueryTx) Exec(query string, args ...interface{}) (sql.Result, err
 		querytx.lastsql.CostTime = time.Since(start)
 
 	}()
-	ctx := context.TODO()
+	ctx := querytx.context()
 	var res sql.Result
 	var err error
 	//添加预处理
@@ -156,7 +230,7 @@ func (querytx *QueryTx) Query(query string, args ...interface{}) (*sql.Rows, err
 	defer func() {
 		querytx.lastsql.CostTime = time.Since(start)
 	}()
-	ctx := context.TODO()
+	ctx := querytx.context()
 	var res *sql.Rows
 	var err error
 
@@ -169,6 +243,14 @@ func (querytx *QueryTx) Query(query string, args ...interface{}) (*sql.Rows, err
 	return res, err
 }
 
+//context 获取执行语句使用的 context
+func (querytx *QueryTx) context() context.Context {
+	if querytx.ctx != nil {
+		return querytx.ctx
+	}
+	return context.TODO()
+}
+
 //GetLastSql 获取sql语句
 func (querytx *QueryTx) GetLastSql() Sql {
 	return querytx.lastsql
@@ -230,6 +312,8 @@ func convert(s string, v interface{}) string {
 		} else {
 			return strings.Replace(s, "?", strconv.Quote(fmt.Sprintf("%v", v)), 1)
 		}
+	case []byte:
+		return strings.Replace(s, "?", fmt.Sprintf("X'%X'", v), 1)
 	}
 	return strings.Replace(s, "?", fmt.Sprintf("%v", v), 1)
 }
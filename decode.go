@@ -0,0 +1,165 @@
+package querydb
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DecimalMode DECIMAL 字段的解码方式
+type DecimalMode int
+
+const (
+	DecimalString  DecimalMode = iota //字符串，保留精度
+	DecimalFloat64                    //float64
+)
+
+//UnsignedMode BIGINT UNSIGNED 字段的解码方式
+type UnsignedMode int
+
+const (
+	UnsignedUint64 UnsignedMode = iota //uint64
+	UnsignedString                     //字符串，便于 JSON 等场景避免精度丢失
+)
+
+//decodeOptions 解码选项
+type decodeOptions struct {
+	decimal  DecimalMode
+	unsigned UnsignedMode
+	loc      *time.Location
+	null     string //NULL 的占位字符串
+	strict   bool   //严格映射
+	configs  *Configs
+}
+
+//newDecodeOptions 根据配置生成解码选项
+func newDecodeOptions(link *Config) decodeOptions {
+	opts := decodeOptions{loc: time.Local}
+	if link != nil {
+		opts.loc = link.location()
+		opts.decimal = link.Decimal
+		opts.unsigned = link.Unsigned
+		opts.null = link.NullAs
+		opts.strict = link.Strict
+	}
+	return opts
+}
+
+//columnDecoder 按数据库字段类型解码
+type columnDecoder struct {
+	name     string
+	typeName string //DatabaseTypeName，如 INT、UNSIGNED BIGINT、DECIMAL、DATETIME
+	opts     decodeOptions
+}
+
+//newColumnDecoders 根据 sql.ColumnType 生成解码器
+func newColumnDecoders(types []*sql.ColumnType, opts decodeOptions) []columnDecoder {
+	decoders := make([]columnDecoder, len(types))
+	for i, ct := range types {
+		decoders[i] = columnDecoder{name: ct.Name(), typeName: strings.ToUpper(ct.DatabaseTypeName()), opts: opts}
+	}
+	return decoders
+}
+
+//decode 将驱动返回的值转换为 int64、uint64、float64、string、[]byte、time.Time 或 nil
+func (d columnDecoder) decode(src interface{}) (interface{}, error) {
+	if src == nil {
+		return nil, nil
+	}
+	switch d.typeName {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR",
+		"UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED INT":
+		return decodeInt(src)
+	case "UNSIGNED BIGINT":
+		u, err := decodeUint(src)
+		if err != nil || d.opts.unsigned != UnsignedString {
+			return u, err
+		}
+		return strconv.FormatUint(u, 10), nil
+	case "DECIMAL":
+		s := decodeString(src)
+		if d.opts.decimal == DecimalFloat64 {
+			return strconv.ParseFloat(s, 64)
+		}
+		return s, nil
+	case "FLOAT", "DOUBLE":
+		return decodeFloat(src)
+	case "DATETIME", "TIMESTAMP", "DATE":
+		return decodeTime(src, d.opts.loc)
+	case "BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		if b, ok := src.([]byte); ok {
+			return append([]byte(nil), b...), nil
+		}
+		return src, nil
+	}
+	//文本类型及未知类型，文本按字符串返回
+	if b, ok := src.([]byte); ok {
+		return string(b), nil
+	}
+	return src, nil
+}
+
+func decodeString(src interface{}) string {
+	switch v := src.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%v", src)
+}
+
+func decodeInt(src interface{}) (int64, error) {
+	switch v := src.(type) {
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	}
+	return strconv.ParseInt(decodeString(src), 10, 64)
+}
+
+func decodeUint(src interface{}) (uint64, error) {
+	switch v := src.(type) {
+	case int64:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	}
+	return strconv.ParseUint(decodeString(src), 10, 64)
+}
+
+func decodeFloat(src interface{}) (float64, error) {
+	switch v := src.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	return strconv.ParseFloat(decodeString(src), 64)
+}
+
+//decodeTime 解析时间，未开启 parseTime 时驱动返回文本
+func decodeTime(src interface{}, loc *time.Location) (interface{}, error) {
+	if t, ok := src.(time.Time); ok {
+		return t, nil
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	s := decodeString(src)
+	if strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil
+	}
+	layout := "2006-01-02 15:04:05.999999"
+	if len(s) == len("2006-01-02") {
+		layout = "2006-01-02"
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
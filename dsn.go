@@ -0,0 +1,164 @@
+package querydb
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//FormatDSN 构造 go-sql-driver/mysql 的 DSN，用户名、密码等按驱动的规则处理，配置了 TLS 证书文件时注册对应的 TLS 配置
+func (config *Config) FormatDSN() (string, error) {
+	cfg, err := config.MySQLConfig()
+	if err != nil {
+		return "", err
+	}
+	return cfg.FormatDSN(), nil
+}
+
+//MySQLConfig 转换为 go-sql-driver/mysql 的配置
+func (config *Config) MySQLConfig() (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = config.Username
+	cfg.Passwd = config.Password
+	cfg.Net = "tcp"
+	cfg.Addr = config.addr()
+	cfg.DBName = config.Database
+	cfg.Loc = config.location()
+	cfg.Timeout = config.Timeout
+	cfg.ReadTimeout = config.ReadTimeout
+	cfg.WriteTimeout = config.WriteTimeout
+	cfg.ParseTime = config.ParseTime
+	cfg.InterpolateParams = config.InterpolateParams
+	if config.Collation != "" {
+		cfg.Collation = config.Collation
+	}
+	cfg.Params = make(map[string]string, len(config.Params)+1)
+	for k, v := range config.Params {
+		cfg.Params[k] = v
+	}
+	if config.Charset != "" {
+		cfg.Params["charset"] = config.Charset
+	}
+
+	tlsName, err := config.tlsConfigName()
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLSConfig = tlsName
+	return cfg, nil
+}
+
+//ParseDSN 解析 go-sql-driver/mysql 格式的 DSN，如 user:pass@tcp(127.0.0.1:3306)/db?charset=utf8mb4&parseTime=true
+func ParseDSN(dsn string) (*Config, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Net != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q in dsn", cfg.Net)
+	}
+	host, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Username:          cfg.User,
+		Password:          cfg.Passwd,
+		Host:              host,
+		Port:              port,
+		Database:          cfg.DBName,
+		Loc:               cfg.Loc,
+		Timeout:           cfg.Timeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		ParseTime:         cfg.ParseTime,
+		InterpolateParams: cfg.InterpolateParams,
+		Collation:         cfg.Collation,
+		TLS:               cfg.TLSConfig,
+	}
+	if len(cfg.Params) > 0 {
+		config.Params = make(map[string]string, len(cfg.Params))
+		for k, v := range cfg.Params {
+			if k == "charset" {
+				config.Charset = v
+				continue
+			}
+			config.Params[k] = v
+		}
+	}
+	return config, nil
+}
+
+//addr host:port，默认 127.0.0.1:3306
+func (config *Config) addr() string {
+	host, port := config.Host, config.Port
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if port == "" {
+		port = "3306"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+//location 时区，默认 time.Local
+func (config *Config) location() *time.Location {
+	if config.Loc != nil {
+		return config.Loc
+	}
+	return time.Local
+}
+
+//tlsConfigName 获取 DSN 中 tls 参数的值，配置了证书文件时以文件路径生成名称并注册
+func (config *Config) tlsConfigName() (string, error) {
+	if config.TLSCAFile == "" && config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		return config.TLS, nil
+	}
+	switch config.TLS {
+	case "", "true", "skip-verify", "preferred":
+	default:
+		return "", fmt.Errorf("tls %q cannot be used with certificate files", config.TLS)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.TLS == "skip-verify",
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = config.Host
+	}
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return "", err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", errors.New("no certificates found in " + config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	sum := sha1.Sum([]byte(strings.Join([]string{config.TLS, config.TLSCAFile, config.TLSCertFile, config.TLSKeyFile, tlsConfig.ServerName}, "\x00")))
+	name := "querydb-" + hex.EncodeToString(sum[:8])
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,63 @@
+package querydb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestFormatDSNSpecialPassword(t *testing.T) {
+	config := &Config{Username: "app", Password: "p@ss/w:rd?#", Host: "db.local", Port: "3307", Database: "shop", Charset: "utf8mb4"}
+	dsn, err := config.FormatDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("driver cannot parse %q: %v", dsn, err)
+	}
+	if cfg.User != "app" || cfg.Passwd != config.Password || cfg.Addr != "db.local:3307" || cfg.DBName != "shop" {
+		t.Fatalf("parsed %q as user %q password %q addr %q db %q", dsn, cfg.User, cfg.Passwd, cfg.Addr, cfg.DBName)
+	}
+	if cfg.Params["charset"] != "utf8mb4" {
+		t.Fatalf("charset = %q", cfg.Params["charset"])
+	}
+}
+
+func TestParseDSNRoundTrip(t *testing.T) {
+	dsns := []string{
+		"app:p@ss/word@tcp(10.0.0.1:3306)/shop?charset=utf8mb4&parseTime=true&loc=Asia%2FShanghai",
+		"root:@tcp(127.0.0.1:3306)/ott?timeout=3s&readTimeout=1s&writeTimeout=2s&interpolateParams=true&sql_mode=%27STRICT_ALL_TABLES%27",
+		"app:secret@tcp(db.local:3307)/?collation=utf8mb4_general_ci&tls=skip-verify",
+	}
+	for _, dsn := range dsns {
+		config, err := ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("ParseDSN(%q): %v", dsn, err)
+		}
+		formatted, err := config.FormatDSN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := ParseDSN(formatted)
+		if err != nil {
+			t.Fatalf("ParseDSN(%q): %v", formatted, err)
+		}
+		if !reflect.DeepEqual(config, again) {
+			t.Errorf("round trip of %q\n got %+v\nwant %+v", dsn, again, config)
+		}
+	}
+
+	config, err := ParseDSN(dsns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "p@ss/word" || config.Host != "10.0.0.1" || config.Port != "3306" || !config.ParseTime || config.Loc.String() != "Asia/Shanghai" {
+		t.Fatalf("parsed %+v", config)
+	}
+	if config, err = ParseDSN(dsns[1]); err != nil || config.Timeout != 3*time.Second || config.Params["sql_mode"] != "'STRICT_ALL_TABLES'" {
+		t.Fatalf("parsed %+v, %v", config, err)
+	}
+}
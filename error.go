@@ -3,6 +3,7 @@ package querydb
 type DbError struct {
 	msg string
 	sql Sql
+	err error
 }
 
 type Epr struct {
@@ -20,6 +21,29 @@ func NewDBError(msg string, sql Sql) DbError {
 	return DbError{msg: msg, sql: sql}
 }
 
+//newDBError 包装执行语句的错误，可用 errors.Is 判断原错误，如 ErrClosed
+func newDBError(err error, sql Sql) DbError {
+	return DbError{msg: err.Error(), sql: sql, err: err}
+}
+
 func (e DbError) Error() string {
 	return "DBError:" + e.msg + " " + e.sql.ToJson()
-}
\ No newline at end of file
+}
+
+func (e DbError) Unwrap() error {
+	return e.err
+}
+
+//SafeError 安全模式错误
+type SafeError struct {
+	msg string
+	sql Sql
+}
+
+func NewSafeError(msg string, sql Sql) SafeError {
+	return SafeError{msg: msg, sql: sql}
+}
+
+func (e SafeError) Error() string {
+	return "SafeError:" + e.msg + " " + e.sql.ToJson()
+}
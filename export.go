@@ -0,0 +1,202 @@
+package querydb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+//CSVOptions ToCSV 的选项
+type CSVOptions struct {
+	Comma      rune   //分隔符，默认为逗号
+	UseCRLF    bool   //使用 \r\n 换行
+	NoHeader   bool   //不输出字段名的表头
+	BOM        bool   //输出 UTF-8 BOM，便于 Excel 识别编码
+	Null       string //NULL 输出的字符串，默认空字符串
+	TimeFormat string //时间格式，默认 2006-01-02 15:04:05
+}
+
+//ToCSV 逐行写入 CSV，第一行为字段名，值按字段类型格式化，不会一次性读取全部结果
+func (r *Rows) ToCSV(w io.Writer, opts CSVOptions) error {
+	bw := bufio.NewWriter(w)
+	if opts.BOM {
+		if _, err := bw.WriteString("\xEF\xBB\xBF"); err != nil {
+			return err
+		}
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = "2006-01-02 15:04:05"
+	}
+	cw := csv.NewWriter(bw)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	cw.UseCRLF = opts.UseCRLF
+
+	var record []string
+	err := r.stream(func(columns []string) error {
+		record = make([]string, len(columns))
+		if opts.NoHeader {
+			return nil
+		}
+		return cw.Write(columns)
+	}, func(values []interface{}) error {
+		for i, v := range values {
+			record[i] = formatCSV(v, opts)
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+//ToJSONLines 逐行写入 JSON Lines，每行一个以字段名为键的 JSON 对象，字段顺序与查询一致
+func (r *Rows) ToJSONLines(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var keys [][]byte
+	err := r.stream(func(columns []string) (err error) {
+		keys, err = jsonKeys(columns)
+		return err
+	}, func(values []interface{}) error {
+		if err := writeJSONObject(bw, keys, values); err != nil {
+			return err
+		}
+		return bw.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+//ToNDJSON 同 ToJSONLines
+func (r *Rows) ToNDJSON(w io.Writer) error {
+	return r.ToJSONLines(w)
+}
+
+//ToJSON 写入 JSON 数组，逐行输出对象，没有记录时输出 []
+func (r *Rows) ToJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var keys [][]byte
+	n := 0
+	err := r.stream(func(columns []string) (err error) {
+		keys, err = jsonKeys(columns)
+		return err
+	}, func(values []interface{}) error {
+		sep := byte(',')
+		if n == 0 {
+			sep = '['
+		}
+		n++
+		if err := bw.WriteByte(sep); err != nil {
+			return err
+		}
+		return writeJSONObject(bw, keys, values)
+	})
+	if err != nil {
+		return err
+	}
+	end := "]"
+	if n == 0 {
+		end = "[]"
+	}
+	if _, err := bw.WriteString(end); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+//stream 逐行按字段类型解码，header 处理查询字段，fn 处理每一行的值，values 在下一行复用
+func (r *Rows) stream(header func(columns []string) error, fn func(values []interface{}) error) error {
+	var refs, values []interface{}
+	var decoders []columnDecoder
+	return r.each(0, func(fields []string) error {
+		types, err := r.rs.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		decoders = newColumnDecoders(types, r.decode)
+		refs = rawRefs(fields)
+		values = make([]interface{}, len(fields))
+		return header(fields)
+	}, func() ([]interface{}, error) {
+		return refs, nil
+	}, func() error {
+		for i := range refs {
+			val, err := decoders[i].decode(*refs[i].(*interface{}))
+			if err != nil {
+				return err
+			}
+			values[i] = val
+		}
+		return fn(values)
+	})
+}
+
+//formatCSV 格式化 CSV 的值，[]byte 输出为 base64
+func formatCSV(v interface{}, opts CSVOptions) string {
+	switch val := v.(type) {
+	case nil:
+		return opts.Null
+	case string:
+		return val
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case uint64:
+		return strconv.FormatUint(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		if val.IsZero() {
+			return ""
+		}
+		return val.Format(opts.TimeFormat)
+	}
+	s, _ := toString(&v)
+	return s
+}
+
+//jsonKeys 生成 JSON 对象的键
+func jsonKeys(columns []string) ([][]byte, error) {
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+//writeJSONObject 按字段顺序写入 JSON 对象
+func writeJSONObject(w *bufio.Writer, keys [][]byte, values []interface{}) error {
+	w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(keys[i])
+		w.WriteByte(':')
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+	}
+	return w.WriteByte('}')
+}
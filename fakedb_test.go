@@ -0,0 +1,206 @@
+package querydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+//fakeDriver 测试用的驱动，每个 DSN 对应一个 fakeServer，记录执行的语句并按语句内容返回结果
+type fakeDriver struct{}
+
+//fakeResult 查询结果，types 为列的数据库类型，如 DECIMAL、BIGINT UNSIGNED
+type fakeResult struct {
+	cols  []string
+	types []string
+	rows  [][]driver.Value
+}
+
+//fakeCall 执行的语句，BEGIN、COMMIT、ROLLBACK 也会记录
+type fakeCall struct {
+	query string
+	args  []driver.Value
+}
+
+type fakeServer struct {
+	mu       sync.Mutex
+	results  map[string]fakeResult //语句包含 key 时返回对应结果
+	affected int64                 //Exec 返回的影响行数
+	execErr  error                 //Exec 返回的错误
+	calls    []fakeCall
+}
+
+var (
+	fakeServers  sync.Map
+	fakeSequence int64
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+//newFakeServer 创建独立的 fakeServer 及对应的 *sql.DB
+func newFakeServer(t testing.TB) (*fakeServer, *sql.DB) {
+	dsn := "fake-" + strconv.FormatInt(atomic.AddInt64(&fakeSequence, 1), 10)
+	server := &fakeServer{results: make(map[string]fakeResult), affected: 1}
+	fakeServers.Store(dsn, server)
+	db, err := sql.Open("fakedb", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeServers.Delete(dsn)
+	})
+	return server, db
+}
+
+//newFakeDb 创建使用 fakeServer 的连接
+func newFakeDb(t testing.TB) (*QueryDb, *fakeServer) {
+	server, db := newFakeServer(t)
+	return &QueryDb{db: db, name: "default", link: &Config{}, configs: Default(), active: &activity{}}, server
+}
+
+//result 设置语句包含 key 时返回的结果
+func (s *fakeServer) result(key string, cols []string, rows ...[]driver.Value) {
+	s.mu.Lock()
+	s.results[key] = fakeResult{cols: cols, rows: rows}
+	s.mu.Unlock()
+}
+
+//executed 已执行的语句
+func (s *fakeServer) executed() []fakeCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeCall(nil), s.calls...)
+}
+
+func (s *fakeServer) record(query string, args []driver.Value) {
+	s.mu.Lock()
+	s.calls = append(s.calls, fakeCall{query: query, args: args})
+	s.mu.Unlock()
+}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	server, ok := fakeServers.Load(dsn)
+	if !ok {
+		return nil, driver.ErrBadConn
+	}
+	return &fakeConn{server: server.(*fakeServer)}, nil
+}
+
+type fakeConn struct {
+	server *fakeServer
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{server: c.server, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.server.record("BEGIN", nil)
+	return &fakeTx{server: c.server}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	return nil
+}
+
+type fakeTx struct {
+	server *fakeServer
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.server.record("COMMIT", nil)
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.server.record("ROLLBACK", nil)
+	return nil
+}
+
+type fakeStmt struct {
+	server *fakeServer
+	query  string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.server.record(s.query, args)
+	s.server.mu.Lock()
+	defer s.server.mu.Unlock()
+	if s.server.execErr != nil {
+		return nil, s.server.execErr
+	}
+	return fakeExecResult(s.server.affected), nil
+}
+
+//fakeExecResult Exec 的结果，LastInsertId 固定为 1
+type fakeExecResult int64
+
+func (r fakeExecResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (r fakeExecResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.server.record(s.query, args)
+	s.server.mu.Lock()
+	defer s.server.mu.Unlock()
+	for key, result := range s.server.results {
+		if strings.Contains(s.query, key) {
+			return &fakeRows{result: result}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	i      int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.result.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.i])
+	r.i++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.result.types) {
+		return r.result.types[i]
+	}
+	return ""
+}
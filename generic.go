@@ -0,0 +1,53 @@
+package querydb
+
+import (
+	"errors"
+	"iter"
+	"reflect"
+)
+
+//errStopIter 迭代被调用方提前结束
+var errStopIter = errors.New("iteration stopped")
+
+//Find 查询全部记录，T 为结构体、结构体指针或单个字段的类型，没有记录时返回空切片
+//	users, err := querydb.Find[User](db.NewQuery().Table("user").Where("status", 1))
+func Find[T any](query *QueryBuilder) ([]T, error) {
+	items := make([]T, 0)
+	if err := query.Rows().Scan(&items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//First 查询一条记录，没有记录时返回 ErrNoRows
+//	user, err := querydb.First[User](db.NewQuery().Table("user").Where("id", 1))
+func First[T any](query *QueryBuilder) (T, error) {
+	var item T
+	if err := query.Row().Scan(&item); err != nil {
+		var zero T
+		return zero, err
+	}
+	return item, nil
+}
+
+//Iter 逐行迭代查询结果，不会一次性读取全部记录，出错时以零值和错误结束迭代
+//	for user, err := range querydb.Iter[User](db.NewQuery().Table("user")) {
+//		if err != nil {
+//			return err
+//		}
+//	}
+func Iter[T any](query *QueryBuilder) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		rows := query.Rows()
+		err := rows.scanEach(reflect.TypeOf((*T)(nil)).Elem(), 0, func(v reflect.Value) error {
+			if !yield(v.Interface().(T), nil) {
+				return errStopIter
+			}
+			return nil
+		})
+		if err != nil && err != errStopIter {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -1,5 +1,9 @@
 module github.com/yinluobing/querydb
 
-go 1.17
+go 1.23
 
-require github.com/go-sql-driver/mysql v1.6.0
+require (
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/pelletier/go-toml/v2 v2.2.4
+	gopkg.in/yaml.v3 v3.0.1
+)
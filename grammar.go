@@ -31,6 +31,9 @@ func (g Grammar) compileTable(from bool) string {
 }
 func (g Grammar) compileOrder(isUnion bool) string {
 	orders := g.builder.orders
+	if len(g.builder.scopeOrder) > 0 {
+		orders = append(orders[:len(orders):len(orders)], g.builder.scopeOrder...)
+	}
 	if isUnion {
 		orders = g.builder.unOrders
 	}
@@ -68,17 +71,30 @@ func (g Grammar) compileDistinct() string {
 	return ""
 }
 func (g Grammar) compileWhere() string {
-	len := len(g.builder.where)
+	scope := g.compileConditions(g.builder.scopeWhere)
+	where := g.compileConditions(g.builder.where)
+	if scope == "" && where == "" {
+		return ""
+	}
+	if scope == "" {
+		return " WHERE " + where
+	}
+	if where == "" {
+		return " WHERE " + scope
+	}
+	return " WHERE " + scope + " AND (" + where + ")"
+}
+func (g Grammar) compileConditions(w []w) string {
+	len := len(w)
 	if len < 1 {
 		return ""
 	}
-	w := g.builder.where
-	sql := " WHERE "
+	sql := ""
 	for i := 0; i < len; i++ {
 		if i > 0 {
-			sql += " " + w[i].do
+			sql += " " + w[i].do + " "
 		}
-		sql += " " + w[i].column
+		sql += w[i].column
 		if w[i].operator != "" {
 			switch w[i].operator {
 			case BETWEEN, NOTBETWEEN:
@@ -89,7 +105,6 @@ func (g Grammar) compileWhere() string {
 				sql += " " + w[i].operator + "(?" + strings.Repeat(",?", intNum) + ")"
 			case ISNULL, ISNOTNULL:
 				sql += " " + w[i].operator
-				break
 			default:
 				sql += " " + w[i].operator + " ?"
 			}
@@ -106,7 +121,12 @@ func (g Grammar) compileJoin() string {
 	sql := ""
 	joins := g.builder.joins
 	for i := 0; i < len; i++ {
-		sql += " " + joins[i].operator + " " + joins[i].table + " ON " + joins[i].on
+		sql += " " + joins[i].operator + " " + joins[i].table + " ON "
+		if joins[i].tenant != "" {
+			sql += "(" + joins[i].on + ") AND " + joins[i].tenant + " = ?"
+		} else {
+			sql += joins[i].on
+		}
 	}
 	return sql
 }
@@ -149,6 +169,9 @@ func (g Grammar) Select() string {
 	sql += g.compileUnion()
 	sql += g.compileLimit(true)
 	sql += g.compileOrder(true)
+	if g.builder.lock != "" {
+		sql += " " + g.builder.lock
+	}
 
 	return sql
 }
@@ -179,7 +202,7 @@ func (g Grammar) compileInsertValue() string {
 		d := g.builder.data[index]
 		for i := 0; i < columnsLen; i++ {
 			field := columns[i]
-			g.builder.addArg(d[field])
+			g.builder.args = append(g.builder.args, d[field]) //写入的值在 getInsertMap 中已编码
 		}
 	}
 	sql += strings.Join(g.builder.columns, ",")
@@ -0,0 +1,166 @@
+package querydb
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//lagUnknown 复制已停止或无法获取延迟
+const lagUnknown = time.Duration(math.MaxInt64)
+
+//lagProbe 定期获取已建立连接的从库的复制延迟
+func (configs *Configs) lagProbe(pool *replicaPool) {
+	interval := pool.config.LagCheckInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, r := range pool.replicas {
+			configs.mu.RLock()
+			conn, ok := configs.connections[r.key]
+			configs.mu.RUnlock()
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			lag, err := replicationLag(ctx, conn.db, pool.config.LagQuery)
+			cancel()
+			if err != nil {
+				Log.Warn("replica " + r.config.Host + ":" + r.config.Port + " lag probe failed: " + err.Error())
+				lag = lagUnknown
+			}
+			atomic.StoreInt64(&r.lag, int64(lag))
+		}
+		select {
+		case <-pool.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//replicationLag 获取复制延迟，query 为空时使用 SHOW REPLICA STATUS，否则执行 query 获取延迟的秒数，如心跳表
+//	SELECT TIMESTAMPDIFF(MICROSECOND, ts, NOW(6)) / 1e6 FROM heartbeat
+func replicationLag(ctx context.Context, db *sql.DB, query string) (time.Duration, error) {
+	if query != "" {
+		var seconds sql.NullFloat64
+		if err := db.QueryRowContext(ctx, query).Scan(&seconds); err != nil {
+			return 0, err
+		}
+		if !seconds.Valid {
+			return lagUnknown, nil
+		}
+		return time.Duration(seconds.Float64 * float64(time.Second)), nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		//MySQL 8.0.22 之前的版本
+		if rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+	fields, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		//不是从库
+		return lagUnknown, rows.Err()
+	}
+	refs := rawRefs(fields)
+	if err := rows.Scan(refs...); err != nil {
+		return 0, err
+	}
+	for i, field := range fields {
+		if field != "Seconds_Behind_Source" && field != "Seconds_Behind_Master" {
+			continue
+		}
+		seconds, err := toNullString(refs[i])
+		if err != nil || seconds == nil {
+			//NULL 表示复制线程未运行
+			return lagUnknown, err
+		}
+		n, err := decodeInt(*seconds)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+	return lagUnknown, nil
+}
+
+//lagging 从库的复制延迟是否超过 MaxLag
+func (r *replica) lagging() bool {
+	max := r.pool.config.MaxLag
+	return max > 0 && time.Duration(atomic.LoadInt64(&r.lag)) > max
+}
+
+//Session 读己之写的会话，写入后 ReadYourWrites 时间内的读取使用主库
+type Session struct {
+	mu     sync.Mutex
+	writes map[string]time.Time //配置名称 => 最后写入时间
+}
+
+type sessionKey struct{}
+
+//WithSession 在 context 中开启读己之写的会话，使用 WriteContext 写入后，ReadContext 在 ReadYourWrites 时间内返回主库
+func WithSession(ctx context.Context) context.Context {
+	if _, ok := SessionFromContext(ctx); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKey{}, &Session{writes: make(map[string]time.Time)})
+}
+
+//SessionFromContext 获取 context 中的会话
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	return session, ok
+}
+
+//write 记录写入
+func (session *Session) write(name string) {
+	session.mu.Lock()
+	session.writes[name] = time.Now()
+	session.mu.Unlock()
+}
+
+//pinned 是否在写入后的 window 时间内
+func (session *Session) pinned(name string, window time.Duration) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	last, ok := session.writes[name]
+	return ok && time.Since(last) < window
+}
+
+//pinned context 中的会话是否在写入后的 ReadYourWrites 时间内
+func (configs *Configs) pinned(ctx context.Context, name string) bool {
+	session, ok := SessionFromContext(ctx)
+	if !ok {
+		return false
+	}
+	configs.mu.RLock()
+	config, exists := configs.cfg[name]
+	configs.mu.RUnlock()
+	return exists && session.pinned(name, config.ReadYourWrites)
+}
+
+//markWrite 主库写入后记录到 context 中的会话，name 为空表示不是主库
+func markWrite(ctx context.Context, link *Config, name string) {
+	if name == "" || link == nil || link.ReadYourWrites <= 0 {
+		return
+	}
+	if session, ok := SessionFromContext(ctx); ok {
+		session.write(name)
+	}
+}
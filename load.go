@@ -0,0 +1,536 @@
+package querydb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+//ConfigError 配置文件或环境变量中的错误，Key 为出错的键，如 default.slave[0].max_lifetime
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return "ConfigError:" + e.Key + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+//Load 从文件加载配置，按扩展名识别 .json、.yaml、.yml、.toml
+//文件的第一层为配置名称，键名不区分大小写，可使用 max_lifetime、maxLifetime 等形式，时间可写为 "2m" 或秒数
+//字符串类型的键加 _file 后缀时从文件读取，如 password_file: /run/secrets/db_password
+func (configs *Configs) Load(path string) error {
+	loaded, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	return configs.loadConfigs(loaded)
+}
+
+//parseFile 解析配置文件，按扩展名识别格式
+func parseFile(path string) (map[string]*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseConfigs(f, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+}
+
+//LoadReader 从 io.Reader 加载配置，format 为 json、yaml、yml 或 toml
+func (configs *Configs) LoadReader(r io.Reader, format string) error {
+	loaded, err := parseConfigs(r, format)
+	if err != nil {
+		return err
+	}
+	return configs.loadConfigs(loaded)
+}
+
+//loadConfigs 设置从文件加载的配置，应用已加载的环境变量并记录配置名称，重新加载时只移除这些配置
+func (configs *Configs) loadConfigs(loaded map[string]*Config) error {
+	loaded, err := configs.withEnv(loaded)
+	if err != nil {
+		return err
+	}
+	configs.setConfigs(loaded)
+	configs.mu.Lock()
+	if configs.fileConfigs == nil {
+		configs.fileConfigs = make(map[string]bool)
+	}
+	for name := range loaded {
+		configs.fileConfigs[name] = true
+	}
+	configs.mu.Unlock()
+	return nil
+}
+
+//parseConfigs 解析配置，先解析为通用的 map 以便校验时给出出错的键
+func parseConfigs(r io.Reader, format string) (map[string]*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	switch strings.ToLower(format) {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, &raw)
+	case "toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make(map[string]*Config, len(raw))
+	for _, name := range sortedKeys(raw) {
+		item, ok := raw[name].(map[string]interface{})
+		if !ok {
+			return nil, &ConfigError{Key: name, Err: fmt.Errorf("expected a table, got %T", raw[name])}
+		}
+		config := &Config{}
+		if err := decodeConfig(config, item, name); err != nil {
+			return nil, err
+		}
+		if err := config.validate(name); err != nil {
+			return nil, err
+		}
+		loaded[name] = config
+	}
+	return loaded, nil
+}
+
+//LoadEnv 从环境变量加载配置，覆盖已有配置的同名字段，Reload、ReloadFile、Watch 重新加载时再次应用
+//	{prefix}_{NAME}_{KEY}，如 DB_DEFAULT_HOST、DB_DEFAULT_MAX_LIFETIME=2m、DB_DEFAULT_PASSWORD_FILE=/run/secrets/db
+//	{prefix}_{NAME}_SLAVE_{N}_{KEY}，如 DB_DEFAULT_SLAVE_0_HOST
+func (configs *Configs) LoadEnv(prefix string) error {
+	env, err := parseEnv(prefix, os.Environ())
+	if err != nil {
+		return err
+	}
+	configs.mu.RLock()
+	loaded := make(map[string]*Config, len(env))
+	for _, name := range sortedKeys(env) {
+		if loaded[name], err = applyEnv(configs.cfg[name], env[name], name); err != nil {
+			break
+		}
+	}
+	configs.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	configs.setConfigs(loaded)
+	configs.mu.Lock()
+	configs.env = append(configs.env, env)
+	configs.mu.Unlock()
+	return nil
+}
+
+//envLayer 一次 LoadEnv 解析的环境变量，配置名称 => 字段
+type envLayer map[string]map[string]interface{}
+
+//parseEnv 解析环境变量，配置名称为小写
+func parseEnv(prefix string, environ []string) (envLayer, error) {
+	prefix = strings.ToUpper(strings.TrimSuffix(prefix, "_")) + "_"
+	raw := make(envLayer)
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		key, value := kv[len(prefix):i], kv[i+1:]
+		name, path, ok := splitEnvKey(strings.Split(key, "_"))
+		if !ok {
+			return nil, &ConfigError{Key: kv[:i], Err: fmt.Errorf("unknown key")}
+		}
+		if raw[name] == nil {
+			raw[name] = make(map[string]interface{})
+		}
+		if len(path) == 1 {
+			raw[name][path[0]] = value
+			continue
+		}
+		//SLAVE_{N}_{KEY}
+		n, _ := strconv.Atoi(path[1])
+		slaves, _ := raw[name]["slave"].([]interface{})
+		for len(slaves) <= n {
+			slaves = append(slaves, map[string]interface{}{})
+		}
+		slaves[n].(map[string]interface{})[path[2]] = value
+		raw[name]["slave"] = slaves
+	}
+	return raw, nil
+}
+
+//applyEnv 复制配置并覆盖环境变量中的字段，config 为 nil 时创建新的配置
+func applyEnv(config *Config, env map[string]interface{}, name string) (*Config, error) {
+	c := &Config{}
+	if config != nil {
+		c = config.clone()
+	}
+	if err := decodeConfig(c, env, name); err != nil {
+		return nil, err
+	}
+	if err := c.validate(name); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//withEnv 按加载顺序在从文件加载的配置上再次应用 LoadEnv 的环境变量
+func (configs *Configs) withEnv(loaded map[string]*Config) (map[string]*Config, error) {
+	configs.mu.RLock()
+	layers := configs.env
+	configs.mu.RUnlock()
+	if len(layers) == 0 {
+		return loaded, nil
+	}
+	merged := make(map[string]*Config, len(loaded))
+	for name, config := range loaded {
+		merged[name] = config
+	}
+	for _, env := range layers {
+		for _, name := range sortedKeys(env) {
+			config, ok := merged[name]
+			if !ok {
+				continue
+			}
+			config, err := applyEnv(config, env[name], name)
+			if err != nil {
+				return nil, err
+			}
+			merged[name] = config
+		}
+	}
+	return merged, nil
+}
+
+//splitEnvKey 拆分环境变量为配置名称及字段，配置名称可以包含下划线，取能匹配字段的最短名称
+func splitEnvKey(parts []string) (name string, path []string, ok bool) {
+	for i := 1; i < len(parts); i++ {
+		rest := parts[i:]
+		key := strings.ToLower(strings.Join(rest, "_"))
+		if configField(key) {
+			return strings.ToLower(strings.Join(parts[:i], "_")), []string{key}, true
+		}
+		if len(rest) > 2 && rest[0] == "SLAVE" {
+			if _, err := strconv.Atoi(rest[1]); err == nil {
+				key = strings.ToLower(strings.Join(rest[2:], "_"))
+				if configField(key) {
+					return strings.ToLower(strings.Join(parts[:i], "_")), []string{"slave", rest[1], key}, true
+				}
+			}
+		}
+	}
+	return "", nil, false
+}
+
+//setConfigs 设置加载的配置
+func (configs *Configs) setConfigs(loaded map[string]*Config) {
+	for _, name := range sortedKeys(loaded) {
+		configs.SetConfig(name, loaded[name])
+	}
+}
+
+//clone 复制配置，包括从库
+func (config *Config) clone() *Config {
+	c := *config
+	c.Slave = nil
+	for _, slave := range config.Slave {
+		c.Slave = append(c.Slave, slave.clone())
+	}
+	return &c
+}
+
+var (
+	configType   = reflect.TypeOf(Config{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	locationType = reflect.TypeOf((*time.Location)(nil))
+	balancerType = reflect.TypeOf((*Balancer)(nil)).Elem()
+)
+
+//normalizeKey 键名去掉下划线、中划线并转为小写
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+//configFieldIndex 根据键名获取 Config 的字段，file 表示为 _file 后缀的文件引用
+func configFieldIndex(key string) (index int, file bool, ok bool) {
+	norm := normalizeKey(key)
+	if norm == "slaves" {
+		norm = "slave"
+	}
+	for i := 0; i < configType.NumField(); i++ {
+		if strings.ToLower(configType.Field(i).Name) == norm {
+			return i, false, true
+		}
+	}
+	if base := strings.TrimSuffix(norm, "file"); base != norm {
+		for i := 0; i < configType.NumField(); i++ {
+			f := configType.Field(i)
+			if strings.ToLower(f.Name) == base && f.Type.Kind() == reflect.String {
+				return i, true, true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+func configField(key string) bool {
+	_, _, ok := configFieldIndex(key)
+	return ok
+}
+
+//decodeConfig 将通用的 map 写入配置，path 为出错时提示的键
+func decodeConfig(config *Config, data map[string]interface{}, path string) error {
+	v := reflect.ValueOf(config).Elem()
+	for _, key := range sortedKeys(data) {
+		raw := data[key]
+		keyPath := path + "." + key
+		i, file, ok := configFieldIndex(key)
+		if !ok {
+			return &ConfigError{Key: keyPath, Err: fmt.Errorf("unknown key")}
+		}
+		if file {
+			name, ok := raw.(string)
+			if !ok {
+				return &ConfigError{Key: keyPath, Err: fmt.Errorf("expected a file path, got %T", raw)}
+			}
+			content, err := os.ReadFile(name)
+			if err != nil {
+				return &ConfigError{Key: keyPath, Err: err}
+			}
+			raw = strings.TrimRight(string(content), "\r\n")
+		}
+		if err := decodeField(v.Field(i), raw, keyPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//decodeField 按字段类型转换
+func decodeField(field reflect.Value, raw interface{}, path string) error {
+	fail := func(err error) error {
+		return &ConfigError{Key: path, Err: err}
+	}
+	typ := field.Type()
+	switch {
+	case typ == durationType:
+		d, err := toDuration(raw)
+		if err != nil {
+			return fail(err)
+		}
+		field.SetInt(int64(d))
+	case typ == locationType:
+		loc, err := time.LoadLocation(fmt.Sprint(raw))
+		if err != nil {
+			return fail(err)
+		}
+		field.Set(reflect.ValueOf(loc))
+	case typ == balancerType:
+		b, err := NewBalancer(fmt.Sprint(raw))
+		if err != nil {
+			return fail(err)
+		}
+		field.Set(reflect.ValueOf(&b).Elem())
+	case typ == reflect.TypeOf(DecimalMode(0)):
+		switch fmt.Sprint(raw) {
+		case "string", "0":
+			field.SetInt(int64(DecimalString))
+		case "float64", "1":
+			field.SetInt(int64(DecimalFloat64))
+		default:
+			return fail(fmt.Errorf("invalid decimal mode %q, expected string or float64", fmt.Sprint(raw)))
+		}
+	case typ == reflect.TypeOf(UnsignedMode(0)):
+		switch fmt.Sprint(raw) {
+		case "uint64", "0":
+			field.SetInt(int64(UnsignedUint64))
+		case "string", "1":
+			field.SetInt(int64(UnsignedString))
+		default:
+			return fail(fmt.Errorf("invalid unsigned mode %q, expected uint64 or string", fmt.Sprint(raw)))
+		}
+	case typ.Kind() == reflect.String:
+		switch val := raw.(type) {
+		case string:
+			field.SetString(val)
+		case json.Number, int, int64, uint64, float64, bool:
+			field.SetString(fmt.Sprint(val))
+		default:
+			return fail(fmt.Errorf("expected a string, got %T", raw))
+		}
+	case typ.Kind() == reflect.Bool:
+		switch val := raw.(type) {
+		case bool:
+			field.SetBool(val)
+		case string:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fail(err)
+			}
+			field.SetBool(b)
+		default:
+			return fail(fmt.Errorf("expected a bool, got %T", raw))
+		}
+	case typ.Kind() == reflect.Int || typ.Kind() == reflect.Int64:
+		n, err := toInt64(raw)
+		if err != nil {
+			return fail(err)
+		}
+		field.SetInt(n)
+	case typ.Kind() == reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return fail(fmt.Errorf("expected a table, got %T", raw))
+		}
+		out := reflect.MakeMapWithSize(typ, len(m))
+		for k, val := range m {
+			out.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(fmt.Sprint(val)))
+		}
+		field.Set(out)
+	case typ == reflect.TypeOf([]*Config{}):
+		list, ok := raw.([]interface{})
+		if !ok {
+			return fail(fmt.Errorf("expected a list, got %T", raw))
+		}
+		slaves := field.Interface().([]*Config)
+		for i, item := range list {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				return &ConfigError{Key: fmt.Sprintf("%s[%d]", path, i), Err: fmt.Errorf("expected a table, got %T", item)}
+			}
+			if i >= len(slaves) {
+				slaves = append(slaves, &Config{})
+			}
+			if err := decodeConfig(slaves[i], m, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		field.Set(reflect.ValueOf(slaves))
+	default:
+		return fail(fmt.Errorf("cannot be set from config"))
+	}
+	return nil
+}
+
+//toDuration 转换时间，字符串按 time.ParseDuration 解析，数字按秒
+func toDuration(raw interface{}) (time.Duration, error) {
+	if s, ok := raw.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+	}
+	f, err := toFloat64(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %v", raw)
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+func toFloat64(raw interface{}) (float64, error) {
+	switch val := raw.(type) {
+	case json.Number:
+		return val.Float64()
+	case int:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	case uint64:
+		return float64(val), nil
+	case float64:
+		return val, nil
+	case string:
+		return strconv.ParseFloat(val, 64)
+	}
+	return 0, fmt.Errorf("expected a number, got %T", raw)
+}
+
+func toInt64(raw interface{}) (int64, error) {
+	switch val := raw.(type) {
+	case json.Number:
+		return val.Int64()
+	case int:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case uint64:
+		if val > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows int64", val)
+		}
+		return int64(val), nil
+	case float64:
+		if val != math.Trunc(val) {
+			return 0, fmt.Errorf("expected an integer, got %v", val)
+		}
+		return int64(val), nil
+	case string:
+		return strconv.ParseInt(val, 10, 64)
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", raw)
+}
+
+//validate 校验配置
+func (config *Config) validate(path string) error {
+	if config.Port != "" {
+		if n, err := strconv.Atoi(config.Port); err != nil || n < 1 || n > 65535 {
+			return &ConfigError{Key: path + ".port", Err: fmt.Errorf("invalid port %q", config.Port)}
+		}
+	}
+	for key, n := range map[string]int64{
+		"max_idle_conns":    int64(config.MaxIdleConns),
+		"max_open_conns":    int64(config.MaxOpenConns),
+		"max_affected_rows": config.MaxAffectedRows,
+		"retries":           int64(config.Retries),
+		"weight":            int64(config.Weight),
+		"max_failures":      int64(config.MaxFailures),
+	} {
+		if n < 0 {
+			return &ConfigError{Key: path + "." + key, Err: fmt.Errorf("must not be negative")}
+		}
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return &ConfigError{Key: path + ".tls_cert_file", Err: fmt.Errorf("tls_cert_file and tls_key_file must be set together")}
+	}
+	for i, slave := range config.Slave {
+		if err := slave.validate(fmt.Sprintf("%s.slave[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//sortedKeys 按键排序，保证加载及报错的顺序固定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
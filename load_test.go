@@ -0,0 +1,220 @@
+package querydb
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//secretFile 写入密码文件，末尾的换行在读取时去掉
+func secretFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfigsFormats(t *testing.T) {
+	secret := secretFile(t)
+	sources := map[string]string{
+		"json": `{"default": {"username": "root", "password_file": "` + secret + `", "host": "127.0.0.1", "port": 3306,
+			"maxLifetime": "2m", "max_idle_time": 30, "balancer": "round_robin", "parse_time": true,
+			"params": {"sql_mode": "'STRICT_ALL_TABLES'"},
+			"slave": [{"host": "10.0.0.2", "weight": 2}, {"host": "10.0.0.3"}]}}`,
+		"yaml": `
+default:
+  username: root
+  password_file: ` + secret + `
+  host: 127.0.0.1
+  port: 3306
+  max-lifetime: 2m
+  max_idle_time: 30
+  balancer: round_robin
+  parse_time: true
+  params:
+    sql_mode: "'STRICT_ALL_TABLES'"
+  slave:
+    - host: 10.0.0.2
+      weight: 2
+    - host: 10.0.0.3
+`,
+		"toml": `
+[default]
+username = "root"
+password_file = "` + secret + `"
+host = "127.0.0.1"
+port = 3306
+max_lifetime = "2m"
+max_idle_time = 30
+balancer = "round_robin"
+parse_time = true
+params = { sql_mode = "'STRICT_ALL_TABLES'" }
+
+[[default.slave]]
+host = "10.0.0.2"
+weight = 2
+
+[[default.slave]]
+host = "10.0.0.3"
+`,
+	}
+	for format, source := range sources {
+		loaded, err := parseConfigs(strings.NewReader(source), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		config := loaded["default"]
+		if config == nil || len(loaded) != 1 {
+			t.Fatalf("%s: loaded %v", format, loaded)
+		}
+		if config.Username != "root" || config.Password != "s3cret" || config.Host != "127.0.0.1" || config.Port != "3306" {
+			t.Errorf("%s: connection = %+v", format, config)
+		}
+		if config.MaxLifetime != 2*time.Minute || config.MaxIdleTime != 30*time.Second || !config.ParseTime {
+			t.Errorf("%s: max_lifetime %v max_idle_time %v parse_time %v", format, config.MaxLifetime, config.MaxIdleTime, config.ParseTime)
+		}
+		if _, ok := config.Balancer.(*RoundRobinBalancer); !ok {
+			t.Errorf("%s: balancer = %T", format, config.Balancer)
+		}
+		if !reflect.DeepEqual(config.Params, map[string]string{"sql_mode": "'STRICT_ALL_TABLES'"}) {
+			t.Errorf("%s: params = %v", format, config.Params)
+		}
+		if len(config.Slave) != 2 || config.Slave[0].Host != "10.0.0.2" || config.Slave[0].Weight != 2 || config.Slave[1].Host != "10.0.0.3" {
+			t.Errorf("%s: slave = %+v", format, config.Slave)
+		}
+	}
+}
+
+func TestParseConfigsErrorKeys(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		key    string
+	}{
+		{"invalid duration", `default: {slave: [{host: a}, {max_lifetime: 2x}]}`, "default.slave[1].max_lifetime"},
+		{"unknown key", `default: {hots: 127.0.0.1}`, "default.hots"},
+		{"invalid port", `default: {port: 70000}`, "default.port"},
+		{"negative", `default: {slave: [{max_open_conns: -1}]}`, "default.slave[0].max_open_conns"},
+		{"not a table", `default: 127.0.0.1`, "default"},
+		{"slave not a table", `default: {slave: [10.0.0.2]}`, "default.slave[0]"},
+		{"missing file", `default: {password_file: /nonexistent/querydb/password}`, "default.password_file"},
+		{"invalid balancer", `default: {balancer: fastest}`, "default.balancer"},
+		{"cert without key", `default: {tls_cert_file: /tmp/cert.pem}`, "default.tls_cert_file"},
+	}
+	for _, c := range cases {
+		_, err := parseConfigs(strings.NewReader(c.source), "yaml")
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			t.Errorf("%s: err = %v, want ConfigError", c.name, err)
+			continue
+		}
+		if configErr.Key != c.key {
+			t.Errorf("%s: key = %q, want %q (%v)", c.name, configErr.Key, c.key, err)
+		}
+	}
+
+	if _, err := parseConfigs(strings.NewReader(`{}`), "ini"); err == nil {
+		t.Error("unsupported format accepted")
+	}
+}
+
+func TestToDuration(t *testing.T) {
+	cases := []struct {
+		raw  interface{}
+		want time.Duration
+	}{
+		{"2m", 2 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"30", 30 * time.Second},
+		{30, 30 * time.Second},
+		{int64(5), 5 * time.Second},
+		{1.5, 1500 * time.Millisecond},
+		{json.Number("0.25"), 250 * time.Millisecond},
+	}
+	for _, c := range cases {
+		got, err := toDuration(c.raw)
+		if err != nil || got != c.want {
+			t.Errorf("toDuration(%#v) = %v, %v, want %v", c.raw, got, err, c.want)
+		}
+	}
+	for _, raw := range []interface{}{"2x", "", true, []interface{}{}} {
+		if _, err := toDuration(raw); err == nil {
+			t.Errorf("toDuration(%#v) succeeded", raw)
+		}
+	}
+}
+
+func TestSplitEnvKey(t *testing.T) {
+	cases := []struct {
+		key  string
+		name string
+		path []string
+		ok   bool
+	}{
+		{"DEFAULT_HOST", "default", []string{"host"}, true},
+		{"DEFAULT_MAX_LIFETIME", "default", []string{"max_lifetime"}, true},
+		{"READ_ONLY_PASSWORD_FILE", "read_only", []string{"password_file"}, true},
+		{"DEFAULT_SLAVE_0_HOST", "default", []string{"slave", "0", "host"}, true},
+		{"REPORT_DB_SLAVE_12_MAX_LIFETIME", "report_db", []string{"slave", "12", "max_lifetime"}, true},
+		{"DEFAULT_SLAVE_X_HOST", "default_slave_x", []string{"host"}, true}, //SLAVE 后不是序号时视为配置名称的一部分
+		{"DEFAULT_BOGUS", "", nil, false},
+		{"HOST", "", nil, false},
+	}
+	for _, c := range cases {
+		name, path, ok := splitEnvKey(strings.Split(c.key, "_"))
+		if name != c.name || !reflect.DeepEqual(path, c.path) || ok != c.ok {
+			t.Errorf("splitEnvKey(%s) = %q, %v, %v, want %q, %v, %v", c.key, name, path, ok, c.name, c.path, c.ok)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	secret := secretFile(t)
+	env, err := parseEnv("DB", []string{
+		"DB_DEFAULT_HOST=10.0.0.1",
+		"DB_DEFAULT_MAX_LIFETIME=2m",
+		"DB_DEFAULT_PASSWORD_FILE=" + secret,
+		"DB_DEFAULT_SLAVE_1_HOST=10.0.0.3",
+		"DB_DEFAULT_SLAVE_1_WEIGHT=3",
+		"PATH=/usr/bin",
+		"DBX_DEFAULT_HOST=ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &Config{Username: "root", Host: "127.0.0.1"}
+	base.SetSlave(&Config{Host: "10.0.0.2"})
+	config, err := applyEnv(base, env["default"], "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Username != "root" || config.Host != "10.0.0.1" || config.Password != "s3cret" || config.MaxLifetime != 2*time.Minute {
+		t.Errorf("config = %+v", config)
+	}
+	if len(config.Slave) != 2 || config.Slave[0].Host != "10.0.0.2" || config.Slave[1].Host != "10.0.0.3" || config.Slave[1].Weight != 3 {
+		t.Errorf("slave = %+v", config.Slave)
+	}
+	if base.Host != "127.0.0.1" || len(base.Slave) != 1 {
+		t.Error("base config was modified")
+	}
+
+	_, err = parseEnv("DB", []string{"DB_DEFAULT_HOTS=10.0.0.1"})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Key != "DB_DEFAULT_HOTS" {
+		t.Errorf("unknown env err = %v", err)
+	}
+	env, err = parseEnv("DB_", []string{"DB_DEFAULT_SLAVE_0_MAX_LIFETIME=2x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = applyEnv(nil, env["default"], "default")
+	if !errors.As(err, &configErr) || configErr.Key != "default.slave[0].max_lifetime" {
+		t.Errorf("invalid duration err = %v", err)
+	}
+}
@@ -1,8 +1,13 @@
 package querydb
 
-var Log Logger
+//Log 日志，默认不输出
+var Log Logger = nopLogger{}
 
+//SetLogger 设置日志，传入 nil 时恢复为不输出
 func (configs *Configs) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
 	Log = logger
 }
 
@@ -16,3 +21,15 @@ type Logger interface {
 	Debug(args ...interface{})
 	Trace(args ...interface{})
 }
+
+//nopLogger 不输出的日志
+type nopLogger struct{}
+
+func (nopLogger) Panic(args ...interface{})   {}
+func (nopLogger) Fatal(args ...interface{})   {}
+func (nopLogger) Error(args ...interface{})   {}
+func (nopLogger) Warning(args ...interface{}) {}
+func (nopLogger) Warn(args ...interface{})    {}
+func (nopLogger) Info(args ...interface{})    {}
+func (nopLogger) Debug(args ...interface{})   {}
+func (nopLogger) Trace(args ...interface{})   {}
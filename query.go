@@ -1,8 +1,9 @@
 package querydb
 
 import (
-	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"math"
 	"reflect"
@@ -33,6 +34,13 @@ const (
 	ASC        = "ASC"
 )
 
+//软删除查询范围
+const (
+	withoutTrashed = iota
+	withTrashed
+	onlyTrashed
+)
+
 // QueryBuilder 查询构造器
 type QueryBuilder struct {
 	connection Connection
@@ -52,6 +60,27 @@ type QueryBuilder struct {
 	unOffset   int64
 	unOrders   []string
 
+	safe        bool  //安全模式
+	fullTable   bool  //允许不带条件的 Update/Delete
+	maxAffected int64 //Update/Delete 最大影响行数
+
+	link       *Config
+	configs    *Configs
+	softDelete string //软删除字段
+	trashed    int    //软删除查询范围
+	force      bool   //强制物理删除
+	scopeWhere []w    //附加条件，与 where 以 AND 组合
+	scopeArgs  []interface{}
+	joinArgs   []interface{} //JOIN 中租户条件的参数
+	scopeOrder []string
+	without    map[string]bool //排除的全局作用域
+	tenant     interface{}     //租户
+	noTenant   bool            //不按租户隔离
+	nullAs     *string         //NULL 的占位字符串
+	strict     bool            //严格映射
+	argErr     error           //参数编码错误
+	lock       string          //FOR UPDATE、LOCK IN SHARE MODE
+
 	args      []interface{}
 	whereArgs []interface{}
 	data      []map[string]interface{}
@@ -60,6 +89,7 @@ type join struct {
 	table    string
 	on       string
 	operator string
+	tenant   string //租户条件的字段，追加到 ON 中
 }
 type union struct {
 	query    QueryBuilder
@@ -72,6 +102,17 @@ type w struct {
 	do       string
 }
 
+//newQuery 根据连接配置生成查询构造器
+func newQuery(conn Connection, link *Config, configs *Configs) *QueryBuilder {
+	query := &QueryBuilder{connection: conn, safe: true, link: link, configs: configs}
+	if link != nil {
+		query.debug = link.Debug
+		query.safe = !link.Unsafe
+		query.maxAffected = link.MaxAffectedRows
+	}
+	return query
+}
+
 //Table 设置操作的表名称
 func (query *QueryBuilder) Table(tablename ...string) *QueryBuilder {
 	query.table = tablename
@@ -267,7 +308,6 @@ func (query *QueryBuilder) RightJoin(tablename string, on string) *QueryBuilder
 func (query *QueryBuilder) Union(unions ...QueryBuilder) *QueryBuilder {
 	for i, len := 0, len(unions); i < len; i++ {
 		query.unions = append(query.unions, union{query: unions[i], operator: UNION})
-		query.addArg(unions[i].args...)
 	}
 	return query
 }
@@ -299,7 +339,6 @@ func (query *QueryBuilder) UnionOrderBy(column string, direction string) *QueryB
 func (query *QueryBuilder) UnionAll(unions ...QueryBuilder) *QueryBuilder {
 	for i, len := 0, len(unions); i < len; i++ {
 		query.unions = append(query.unions, union{query: unions[i], operator: UNIONALL})
-		query.addArg(unions[i].args...)
 	}
 	return query
 }
@@ -345,11 +384,161 @@ func (query *QueryBuilder) Limit(limit int64) *QueryBuilder {
 	return query
 }
 
+//AllowFullTable 允许在安全模式下执行不带条件的 Update/Delete
+func (query *QueryBuilder) AllowFullTable() *QueryBuilder {
+	query.fullTable = true
+	return query
+}
+
+//MaxAffected 设置 Update/Delete 最大影响行数，超出后回滚该语句，0 表示不限制
+func (query *QueryBuilder) MaxAffected(rows int64) *QueryBuilder {
+	query.maxAffected = rows
+	return query
+}
+
+//SoftDelete 声明软删除字段，Delete 将改为更新该字段为当前时间
+func (query *QueryBuilder) SoftDelete(column string) *QueryBuilder {
+	query.softDelete = column
+	return query
+}
+
+//WithTrashed 查询包含已软删除的记录
+func (query *QueryBuilder) WithTrashed() *QueryBuilder {
+	query.trashed = withTrashed
+	return query
+}
+
+//OnlyTrashed 只查询已软删除的记录
+func (query *QueryBuilder) OnlyTrashed() *QueryBuilder {
+	query.trashed = onlyTrashed
+	return query
+}
+
+//NullAs 设置 ToMap/ToArray 中 NULL 的占位字符串
+func (query *QueryBuilder) NullAs(placeholder string) *QueryBuilder {
+	query.nullAs = &placeholder
+	return query
+}
+
+//ForUpdate 查询加排他锁 FOR UPDATE，读写分离的连接中在主库执行
+func (query *QueryBuilder) ForUpdate() *QueryBuilder {
+	query.lock = "FOR UPDATE"
+	return query
+}
+
+//LockInShareMode 查询加共享锁 LOCK IN SHARE MODE，读写分离的连接中在主库执行
+func (query *QueryBuilder) LockInShareMode() *QueryBuilder {
+	query.lock = "LOCK IN SHARE MODE"
+	return query
+}
+
+//Strict 开启严格映射，ToStruct/Scan 时查询字段没有对应成员或成员没有对应字段返回错误
+func (query *QueryBuilder) Strict() *QueryBuilder {
+	query.strict = true
+	return query
+}
+
+//decodeOptions 获取结果解码选项
+func (query *QueryBuilder) decodeOptions() decodeOptions {
+	opts := newDecodeOptions(query.link)
+	if query.nullAs != nil {
+		opts.null = *query.nullAs
+	}
+	if query.strict {
+		opts.strict = true
+	}
+	opts.configs = query.configs
+	return opts
+}
+
 //ToSql 输出SQL语句
 func (query *QueryBuilder) ToSql(method string) string {
+	if err := query.prepare(); err != nil {
+		return ""
+	}
 	grammar := Grammar{builder: query, method: method}
 	return grammar.ToSql()
 }
+//prepare 生成租户、全局作用域、软删除等附加条件
+func (query *QueryBuilder) prepare() error {
+	query.scopeWhere = nil
+	query.scopeArgs = nil
+	query.joinArgs = nil
+	query.scopeOrder = nil
+	if err := query.applyTenant(); err != nil {
+		return err
+	}
+	query.applyGlobalScopes()
+	if query.argErr != nil {
+		return query.argErr
+	}
+	if column := query.softDeleteColumn(); column != "" && !query.force {
+		switch query.trashed {
+		case withoutTrashed:
+			query.scopeWhere = append(query.scopeWhere, w{column: column, operator: ISNULL, do: AND})
+		case onlyTrashed:
+			query.scopeWhere = append(query.scopeWhere, w{column: column, operator: ISNOTNULL, do: AND})
+		}
+	}
+	//UNION 的每个查询同样附加租户、全局作用域、软删除条件，未设置租户时沿用当前查询的租户
+	for i := range query.unions {
+		branch := &query.unions[i].query
+		if branch.tenant == nil && !branch.noTenant {
+			branch.tenant = query.tenant
+		}
+		if err := branch.prepare(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//bindings 按 SET、附加条件、WHERE 的顺序合并参数
+func (query *QueryBuilder) bindings() []interface{} {
+	args := make([]interface{}, 0, len(query.whereArgs)+len(query.scopeArgs)+len(query.args))
+	args = append(args, query.whereArgs...)
+	args = append(args, query.scopeArgs...)
+	return append(args, query.args...)
+}
+
+//selectBindings 按 JOIN、附加条件、WHERE、UNION 的顺序合并 SELECT 的参数
+func (query *QueryBuilder) selectBindings() []interface{} {
+	args := make([]interface{}, 0, len(query.joinArgs)+len(query.scopeArgs)+len(query.args))
+	args = append(args, query.joinArgs...)
+	args = append(args, query.scopeArgs...)
+	args = append(args, query.args...)
+	for i := range query.unions {
+		args = append(args, query.unions[i].query.selectBindings()...)
+	}
+	return args
+}
+
+//softDeleteColumn 获取软删除字段，未声明时从 Config.SoftDeletes 按表名查找
+func (query *QueryBuilder) softDeleteColumn() string {
+	if len(query.table) < 1 {
+		return query.softDelete
+	}
+	name, alias := tableName(query.table[0])
+	column := query.softDelete
+	if column == "" && query.link != nil {
+		column = query.link.SoftDeletes[name]
+	}
+	if column != "" && len(query.joins) > 0 && !strings.Contains(column, ".") {
+		column = alias + "." + column
+	}
+	return column
+}
+
+//tableName 解析 "user AS u" 形式的表名和别名
+func tableName(table string) (name string, alias string) {
+	fields := strings.Fields(table)
+	if len(fields) < 1 {
+		return "", ""
+	}
+	name = strings.Trim(fields[0], "`")
+	return name, strings.Trim(fields[len(fields)-1], "`")
+}
+
 func (query *QueryBuilder) toWhere(column string, operator string, valuenum int64, do string) *QueryBuilder {
 	query.where = append(
 		query.where,
@@ -357,7 +546,13 @@ func (query *QueryBuilder) toWhere(column string, operator string, valuenum int6
 	return query
 }
 func (query *QueryBuilder) addArg(value ...interface{}) {
-	query.args = append(query.args, value...)
+	for _, v := range value {
+		v, err := query.configs.encode(v)
+		if err != nil && query.argErr == nil {
+			query.argErr = err
+		}
+		query.args = append(query.args, v)
+	}
 }
 
 func (query *QueryBuilder) beforeArg(value ...interface{}) {
@@ -368,97 +563,83 @@ func (query *QueryBuilder) setData(data ...map[string]interface{}) {
 	query.data = data
 }
 
-func (b *QueryBuilder) getInsertMap(data interface{}) (columns []string, values map[string][]interface{}, err error) {
+//getInsertMap 获取写入的字段和值，op 为 writeInsert 或 writeUpdate
+func (b *QueryBuilder) getInsertMap(data interface{}, op int) (columns []string, values map[string][]interface{}, err error) {
 	stValue := reflect.Indirect(reflect.ValueOf(data))
 
 	values = make(map[string][]interface{}, 0)
 	switch stValue.Kind() {
 	case reflect.Struct:
-		var ignore bool
-		for i := 0; i < stValue.NumField(); i++ {
-
-			v := reflect.Indirect(stValue.Field(i))
-
-			//处理嵌套的struct中的db映射字段
-			if v.Kind() == reflect.Struct {
-
-				var ignore bool
-
-				switch v.Interface().(type) {
-				case time.Time:
-					ignore = true
-				case sql.NullTime:
-					ignore = true
-				case sql.NullString:
-					ignore = true
-				case sql.NullBool:
-					ignore = true
-				case sql.NullInt64:
-					ignore = true
-				case sql.NullInt32:
-					ignore = true
-				case sql.NullFloat64:
-					ignore = true
+		info, err := cachedStructInfo(stValue.Type())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range info.fields {
+			opts := f.options
+			if opts.readonly {
+				continue
+			}
+			if op == writeUpdate && (opts.pk || opts.autoCreateTime) {
+				continue
+			}
+			//嵌套的指针结构体为 nil 时跳过
+			field, ok := fieldByIndex(stValue, f.index)
+			if !ok {
+				continue
+			}
+			v := reflect.Indirect(field)
+
+			zero := !v.IsValid() || b.IsZero(v)
+			//自动写入时间，可写时同时回写到结构体
+			if opts.autoUpdateTime || (opts.autoCreateTime && zero) {
+				if now, ok := nowValue(field.Type()); ok {
+					if field.CanSet() {
+						field.Set(now)
+					}
+					v, zero = reflect.Indirect(now), false
 				}
+			}
+			if zero && (!opts.zero || opts.autoIncrement) {
+				continue
+			}
 
-				if !ignore {
-					cols, vals, err := b.getInsertMap(v.Interface())
+			var value interface{}
+			if v.IsValid() {
+				value = v.Interface()
+				if opts.json {
+					bs, err := json.Marshal(value)
 					if err != nil {
 						return nil, nil, err
 					}
-
-					for _, column := range cols {
-						if _, ok := values[column]; !ok {
-							columns = append(columns, column)
-						}
-					}
-
-					for column, v := range vals {
-						if _, ok := values[column]; ok {
-							values[column] = append(values[column], v...)
-						} else {
-							values[column] = v
-						}
-					}
+					value = string(bs)
+				} else if value, err = b.configs.encode(value); err != nil {
+					return nil, nil, err
 				}
 			}
-
-			tag := stValue.Type().Field(i).Tag.Get("db")
-			attrList := strings.Split(tag, ",")
-			ignore = false
-
-			if len(attrList) > 0 {
-				for _, attr := range attrList {
-					if attr == "-" {
-						ignore = true
-						break
-					}
-				}
+			//联表映射的 user.name 写入时对应 name 字段
+			column := f.column
+			if i := strings.LastIndex(column, "."); i >= 0 {
+				column = column[i+1:]
 			}
-
-			if ignore {
+			if _, ok := values[column]; ok {
 				continue
 			}
-
-			column := attrList[0]
-			if column != "" && !b.IsZero(v) {
-				if _, ok := values[column]; ok {
-					values[column] = append(values[column], v.Interface())
-				} else {
-					columns = append(columns, column)
-					values[column] = []interface{}{v.Interface()}
-				}
-			}
+			columns = append(columns, column)
+			values[column] = []interface{}{value}
 		}
 	case reflect.Map:
 		keys := stValue.MapKeys()
 		for _, k := range keys {
 			column := k.String()
+			value, err := b.configs.encode(stValue.MapIndex(k).Interface())
+			if err != nil {
+				return nil, nil, err
+			}
 			if _, ok := values[column]; ok {
-				values[column] = append(values[column], stValue.MapIndex(k).Interface())
+				values[column] = append(values[column], value)
 			} else {
 				columns = append(columns, column)
-				values[column] = []interface{}{stValue.MapIndex(k).Interface()}
+				values[column] = []interface{}{value}
 			}
 		}
 	case reflect.Slice:
@@ -466,23 +647,24 @@ func (b *QueryBuilder) getInsertMap(data interface{}) (columns []string, values
 		for i := 0; i < n; i++ {
 
 			item := stValue.Index(i)
-			cols, vals, err := b.getInsertMap(item.Interface())
+			cols, vals, err := b.getInsertMap(item.Interface(), op)
 
 			if err != nil {
 				return nil, nil, err
 			}
 
+			//各行的字段不一致时，缺少的字段补 nil，保证每个字段的值与行对齐
 			for _, column := range cols {
 				if _, ok := values[column]; !ok {
 					columns = append(columns, column)
+					values[column] = make([]interface{}, i)
 				}
 			}
-
-			for column, v := range vals {
-				if _, ok := values[column]; ok {
+			for _, column := range columns {
+				if v, ok := vals[column]; ok {
 					values[column] = append(values[column], v...)
 				} else {
-					values[column] = v
+					values[column] = append(values[column], nil)
 				}
 			}
 		}
@@ -524,7 +706,7 @@ func (query *QueryBuilder) IsZero(v reflect.Value) bool {
 	default:
 		// This should never happens, but will act as a safeguard for
 		// later, as a default value doesn't makes sense here.
-		panic(&reflect.ValueError{"reflect.Value.IsZero", v.Kind()})
+		panic(&reflect.ValueError{Method: "reflect.Value.IsZero", Kind: v.Kind()})
 	}
 }
 
@@ -537,7 +719,7 @@ func (query *QueryBuilder) MultiInsert(datas ...interface{}) (int64, error) {
 	}
 	n := stVal.Len()
 	if n > 0 {
-		columns, values, err := query.getInsertMap(datas)
+		columns, values, err := query.getInsertMap(datas, writeInsert)
 		if err != nil {
 			return 0, err
 		}
@@ -550,6 +732,9 @@ func (query *QueryBuilder) MultiInsert(datas ...interface{}) (int64, error) {
 			bindingsArr[i] = bindings
 		}
 		query.setData(bindingsArr...)
+		if err := query.fillTenant(query.data...); err != nil {
+			return 0, err
+		}
 		grammar := Grammar{builder: query}
 		sql := grammar.Insert()
 		if len(query.columns) < 1 {
@@ -557,7 +742,7 @@ func (query *QueryBuilder) MultiInsert(datas ...interface{}) (int64, error) {
 		}
 		result, err := query.connection.Exec(sql, query.args...)
 		if err != nil {
-			err = NewDBError(err.Error(), query.connection.GetLastSql())
+			err = newDBError(err, query.connection.GetLastSql())
 			return 0, err
 		}
 		return result.RowsAffected()
@@ -574,7 +759,7 @@ func (query *QueryBuilder) MultiInsertSQL(datas ...interface{}) string {
 	}
 	n := stVal.Len()
 	if n > 0 {
-		columns, values, err := query.getInsertMap(datas)
+		columns, values, err := query.getInsertMap(datas, writeInsert)
 		if err != nil {
 			return ""
 		}
@@ -587,6 +772,9 @@ func (query *QueryBuilder) MultiInsertSQL(datas ...interface{}) string {
 			bindingsArr[i] = bindings
 		}
 		query.setData(bindingsArr...)
+		if err := query.fillTenant(query.data...); err != nil {
+			return ""
+		}
 		grammar := Grammar{builder: query}
 		sql := grammar.Insert()
 		if len(query.columns) < 1 {
@@ -607,7 +795,7 @@ func (query *QueryBuilder) Replace(datas ...interface{}) (int64, error) {
 	}
 	n := stVal.Len()
 	if n > 0 {
-		columns, values, err := query.getInsertMap(datas)
+		columns, values, err := query.getInsertMap(datas, writeInsert)
 		if err != nil {
 			return 0, err
 		}
@@ -620,6 +808,9 @@ func (query *QueryBuilder) Replace(datas ...interface{}) (int64, error) {
 			bindingsArr[i] = bindings
 		}
 		query.setData(bindingsArr...)
+		if err := query.fillTenant(query.data...); err != nil {
+			return 0, err
+		}
 		grammar := Grammar{builder: query}
 		sql := grammar.Replace()
 		if len(query.columns) < 1 {
@@ -627,7 +818,7 @@ func (query *QueryBuilder) Replace(datas ...interface{}) (int64, error) {
 		}
 		result, err := query.connection.Exec(sql, query.args...)
 		if err != nil {
-			err = NewDBError(err.Error(), query.connection.GetLastSql())
+			err = newDBError(err, query.connection.GetLastSql())
 			return 0, err
 		}
 		return result.RowsAffected()
@@ -644,7 +835,7 @@ func (query *QueryBuilder) ReplaceSQL(datas ...interface{}) string {
 	}
 	n := stVal.Len()
 	if n > 0 {
-		columns, values, err := query.getInsertMap(datas)
+		columns, values, err := query.getInsertMap(datas, writeInsert)
 		if err != nil {
 			return ""
 		}
@@ -657,6 +848,9 @@ func (query *QueryBuilder) ReplaceSQL(datas ...interface{}) string {
 			bindingsArr[i] = bindings
 		}
 		query.setData(bindingsArr...)
+		if err := query.fillTenant(query.data...); err != nil {
+			return ""
+		}
 		grammar := Grammar{builder: query}
 		sql := grammar.Replace()
 		if len(query.columns) < 1 {
@@ -671,7 +865,7 @@ func (query *QueryBuilder) ReplaceSQL(datas ...interface{}) string {
 //InsertUpdate
 func (query *QueryBuilder) InsertUpdate(insert interface{}, update interface{}) (int64, error) {
 
-	columns, values, err := query.getInsertMap(insert)
+	columns, values, err := query.getInsertMap(insert, writeInsert)
 	if err != nil {
 		return 0, err
 	}
@@ -680,7 +874,7 @@ func (query *QueryBuilder) InsertUpdate(insert interface{}, update interface{})
 		bindingsInsert[column] = values[column][0]
 	}
 
-	columnsup, valuesup, errup := query.getInsertMap(update)
+	columnsup, valuesup, errup := query.getInsertMap(update, writeUpdate)
 	if errup != nil {
 		return 0, errup
 	}
@@ -690,11 +884,14 @@ func (query *QueryBuilder) InsertUpdate(insert interface{}, update interface{})
 	}
 
 	query.setData(bindingsInsert, bindingsUpdate)
+	if err := query.fillTenant(bindingsInsert); err != nil {
+		return 0, err
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.InsertUpdate()
 	result, err := query.connection.Exec(sql, query.args...)
 	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+		err = newDBError(err, query.connection.GetLastSql())
 		return 0, err
 	}
 	return result.RowsAffected()
@@ -703,7 +900,7 @@ func (query *QueryBuilder) InsertUpdate(insert interface{}, update interface{})
 //InsertUpdate
 func (query *QueryBuilder) InsertUpdateSQL(insert interface{}, update interface{}) string {
 
-	columns, values, err := query.getInsertMap(insert)
+	columns, values, err := query.getInsertMap(insert, writeInsert)
 	if err != nil {
 		return err.Error()
 	}
@@ -712,7 +909,7 @@ func (query *QueryBuilder) InsertUpdateSQL(insert interface{}, update interface{
 		bindingsInsert[column] = values[column][0]
 	}
 
-	columnsup, valuesup, errup := query.getInsertMap(update)
+	columnsup, valuesup, errup := query.getInsertMap(update, writeUpdate)
 	if errup != nil {
 		return errup.Error()
 	}
@@ -722,6 +919,9 @@ func (query *QueryBuilder) InsertUpdateSQL(insert interface{}, update interface{
 	}
 
 	query.setData(bindingsInsert, bindingsUpdate)
+	if err := query.fillTenant(bindingsInsert); err != nil {
+		return err.Error()
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.InsertUpdate()
 	query.connection.LastSql(sql, query.args...)
@@ -730,7 +930,7 @@ func (query *QueryBuilder) InsertUpdateSQL(insert interface{}, update interface{
 
 //Insert 插入数据
 func (query *QueryBuilder) Insert(data interface{}) (int64, error) {
-	columns, values, err := query.getInsertMap(data)
+	columns, values, err := query.getInsertMap(data, writeInsert)
 	if err != nil {
 		return 0, err
 	}
@@ -739,19 +939,26 @@ func (query *QueryBuilder) Insert(data interface{}) (int64, error) {
 		bindings[column] = values[column][0]
 	}
 	query.setData(bindings)
+	if err := query.fillTenant(query.data...); err != nil {
+		return 0, err
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Insert()
 	result, err := query.connection.Exec(sql, query.args...)
 	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+		err = newDBError(err, query.connection.GetLastSql())
 		return 0, err
 	}
-	return result.LastInsertId()
+	id, err := result.LastInsertId()
+	if err == nil {
+		setAutoIncrement(data, id)
+	}
+	return id, err
 }
 
 //InsertSQL 获取SQL语句
 func (query *QueryBuilder) InsertSQL(data interface{}) string {
-	columns, values, err := query.getInsertMap(data)
+	columns, values, err := query.getInsertMap(data, writeInsert)
 	if err != nil {
 		return ""
 	}
@@ -760,6 +967,9 @@ func (query *QueryBuilder) InsertSQL(data interface{}) string {
 		bindings[column] = values[column][0]
 	}
 	query.setData(bindings)
+	if err := query.fillTenant(query.data...); err != nil {
+		return ""
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Insert()
 	query.connection.LastSql(sql, query.args...)
@@ -768,7 +978,7 @@ func (query *QueryBuilder) InsertSQL(data interface{}) string {
 
 //Update 更新
 func (query *QueryBuilder) Update(data interface{}) (int64, error) {
-	columns, values, err := query.getInsertMap(data)
+	columns, values, err := query.getInsertMap(data, writeUpdate)
 	if err != nil {
 		return 0, err
 	}
@@ -777,21 +987,22 @@ func (query *QueryBuilder) Update(data interface{}) (int64, error) {
 		bindings[column] = values[column][0]
 	}
 	query.setData(bindings)
+	query.whereArgs = nil
+	if err := query.prepare(); err != nil {
+		return 0, err
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Update()
-	args := append(query.whereArgs, query.args...)
-
-	result, err := query.connection.Exec(sql, args...)
-	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+	args := query.bindings()
+	if err := query.checkSafe(sql, args...); err != nil {
 		return 0, err
 	}
-	return result.RowsAffected()
+	return query.execAffected(sql, args...)
 }
 
 //UpdateSQL 更新
 func (query *QueryBuilder) UpdateSQL(data interface{}) string {
-	columns, values, err := query.getInsertMap(data)
+	columns, values, err := query.getInsertMap(data, writeUpdate)
 	if err != nil {
 		return ""
 	}
@@ -800,33 +1011,142 @@ func (query *QueryBuilder) UpdateSQL(data interface{}) string {
 		bindings[column] = values[column][0]
 	}
 	query.setData(bindings)
+	query.whereArgs = nil
+	if err := query.prepare(); err != nil {
+		return ""
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Update()
-	args := append(query.whereArgs, query.args...)
+	args := query.bindings()
 	query.connection.LastSql(sql, args...)
 	return query.connection.GetLastSql().ToString()
 }
 
-//Delete .
+//Delete 删除，声明了软删除字段时更新该字段为当前时间
 func (query *QueryBuilder) Delete() (int64, error) {
+	if column := query.softDeleteColumn(); column != "" && !query.force {
+		return query.Update(map[string]interface{}{column: time.Now()})
+	}
+	if err := query.prepare(); err != nil {
+		return 0, err
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Delete()
-	result, err := query.connection.Exec(sql, query.args...)
-	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+	args := query.bindings()
+	if err := query.checkSafe(sql, args...); err != nil {
 		return 0, err
 	}
-	return result.RowsAffected()
+	return query.execAffected(sql, args...)
 }
 
 //DeleteSQL .
 func (query *QueryBuilder) DeleteSQL() string {
+	if column := query.softDeleteColumn(); column != "" && !query.force {
+		return query.UpdateSQL(map[string]interface{}{column: time.Now()})
+	}
+	if err := query.prepare(); err != nil {
+		return ""
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Delete()
-	query.connection.LastSql(sql, query.args...)
+	query.connection.LastSql(sql, query.bindings()...)
 	return query.connection.GetLastSql().ToString()
 }
 
+//ForceDelete 忽略软删除，物理删除记录
+func (query *QueryBuilder) ForceDelete() (int64, error) {
+	query.force = true
+	return query.Delete()
+}
+
+//Restore 恢复已软删除的记录
+func (query *QueryBuilder) Restore() (int64, error) {
+	column := query.softDeleteColumn()
+	if column == "" {
+		return 0, errors.New("restore requires a soft delete column")
+	}
+	query.trashed = onlyTrashed
+	return query.Update(map[string]interface{}{column: nil})
+}
+
+//checkSafe 安全模式下禁止不带条件的 Update/Delete
+func (query *QueryBuilder) checkSafe(sql string, args ...interface{}) error {
+	if !query.safe || query.fullTable || len(query.where) > 0 {
+		return nil
+	}
+	return NewSafeError("update/delete without where is not allowed in safe mode, use AllowFullTable()", Sql{Sql: sql, Args: args})
+}
+
+//maxAffectedSavepoint 在调用方的事务中执行时使用的保存点
+const maxAffectedSavepoint = "querydb_max_affected"
+
+//execAffected 执行 Update/Delete，影响行数超出 maxAffected 时回滚该语句
+//在调用方的事务中执行时只回滚到语句前的保存点，事务中之前的语句不受影响，事务仍可继续使用
+func (query *QueryBuilder) execAffected(sql string, args ...interface{}) (int64, error) {
+	if query.maxAffected < 1 {
+		result, err := query.connection.Exec(sql, args...)
+		if err != nil {
+			err = newDBError(err, query.connection.GetLastSql())
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+
+	var tx *QueryTx
+	own := false
+	switch conn := query.connection.(type) {
+	case *QueryTx:
+		tx = conn
+		if _, err := tx.Tx.ExecContext(tx.context(), "SAVEPOINT "+maxAffectedSavepoint); err != nil {
+			return 0, newDBError(err, Sql{Sql: sql, Args: args})
+		}
+	case interface{ Begin() (*QueryTx, error) }:
+		t, err := conn.Begin()
+		if err != nil {
+			return 0, newDBError(err, Sql{Sql: sql, Args: args})
+		}
+		tx, own = t, true
+	default:
+		result, err := query.connection.Exec(sql, args...)
+		if err != nil {
+			return 0, newDBError(err, query.connection.GetLastSql())
+		}
+		return result.RowsAffected()
+	}
+	//rollback 回滚该语句，自己开启的事务整体回滚
+	rollback := func() {
+		if own {
+			tx.Rollback()
+		} else {
+			tx.Tx.ExecContext(tx.context(), "ROLLBACK TO SAVEPOINT "+maxAffectedSavepoint)
+		}
+	}
+
+	result, err := tx.Exec(sql, args...)
+	if own {
+		query.connection.LastSql(sql, args...)
+	}
+	if err == nil {
+		var affected int64
+		if affected, err = result.RowsAffected(); err == nil {
+			if affected > query.maxAffected {
+				rollback()
+				return 0, NewSafeError(fmt.Sprintf("affected rows %d exceeds limit %d, rolled back", affected, query.maxAffected), tx.GetLastSql())
+			}
+			if own {
+				err = tx.Commit()
+			} else {
+				_, err = tx.Tx.ExecContext(tx.context(), "RELEASE SAVEPOINT "+maxAffectedSavepoint)
+			}
+			if err == nil {
+				return affected, nil
+			}
+		}
+	}
+	rollback()
+	return 0, newDBError(err, tx.GetLastSql())
+}
+
 //Count
 func (query *QueryBuilder) Count() (int64, error) {
 	query.Select("COUNT(1) AS _C")
@@ -845,7 +1165,7 @@ func (query *QueryBuilder) Count() (int64, error) {
 func (query *QueryBuilder) Exec(sql string, args ...interface{}) (int64, error) {
 	result, err := query.connection.Exec(sql, args...)
 	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+		err = newDBError(err, query.connection.GetLastSql())
 		return 0, err
 	}
 	return result.RowsAffected()
@@ -860,10 +1180,10 @@ func (query *QueryBuilder) ExecSQL(sql string, args ...interface{}) string {
 func (query *QueryBuilder) QueryRows(sql string, args ...interface{}) *Rows {
 	rows, err := query.connection.Query(sql, args...)
 	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+		err = newDBError(err, query.connection.GetLastSql())
 		return &Rows{rs: nil, lastError: err}
 	}
-	return &Rows{rs: rows, lastError: err}
+	return &Rows{rs: rows, lastError: err, decode: query.decodeOptions()}
 }
 
 //QueryRowsSQL ...
@@ -897,32 +1217,41 @@ func (query *QueryBuilder) Row() *Row {
 }
 
 func (query *QueryBuilder) RowSQL() string {
+	if err := query.prepare(); err != nil {
+		return ""
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Select()
 
-	query.connection.LastSql(sql, query.args...)
+	query.connection.LastSql(sql, query.selectBindings()...)
 	return query.connection.GetLastSql().ToString()
 }
 
 func (query *QueryBuilder) RowsSQL() string {
+	if err := query.prepare(); err != nil {
+		return ""
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Select()
 
-	query.connection.LastSql(sql, query.args...)
+	query.connection.LastSql(sql, query.selectBindings()...)
 	return query.connection.GetLastSql().ToString()
 }
 
 //GetRows 获取多条记录
 func (query *QueryBuilder) Rows() *Rows {
+	if err := query.prepare(); err != nil {
+		return &Rows{rs: nil, lastError: err}
+	}
 	grammar := Grammar{builder: query}
 	sql := grammar.Select()
-	rows, err := query.connection.Query(sql, query.args...)
+	rows, err := query.connection.Query(sql, query.selectBindings()...)
 	if query.debug {
 		log.Print(query.connection.GetLastSql().ToString())
 	}
 	if err != nil {
-		err = NewDBError(err.Error(), query.connection.GetLastSql())
+		err = newDBError(err, query.connection.GetLastSql())
 		return &Rows{rs: nil, lastError: err}
 	}
-	return &Rows{rs: rows, lastError: err}
+	return &Rows{rs: rows, lastError: err, decode: query.decodeOptions()}
 }
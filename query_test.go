@@ -0,0 +1,161 @@
+package querydb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+//statements 已执行的语句，不包括参数
+func statements(server *fakeServer) []string {
+	var list []string
+	for _, call := range server.executed() {
+		list = append(list, call.query)
+	}
+	return list
+}
+
+func TestSafeModeRejectsFullTable(t *testing.T) {
+	db, server := newFakeDb(t)
+
+	_, err := db.NewQuery().Table("user").Update(map[string]interface{}{"status": 1})
+	var safe SafeError
+	if !errors.As(err, &safe) {
+		t.Fatalf("update err = %v, want SafeError", err)
+	}
+	_, err = db.NewQuery().Table("user").Delete()
+	if !errors.As(err, &safe) {
+		t.Fatalf("delete err = %v, want SafeError", err)
+	}
+	if calls := server.executed(); len(calls) != 0 {
+		t.Fatalf("executed %v in safe mode", calls)
+	}
+
+	if _, err := db.NewQuery().Table("user").AllowFullTable().Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if got := statements(server); len(got) != 1 || got[0] != "DELETE  FROM user" {
+		t.Fatalf("executed %q", got)
+	}
+}
+
+func TestMaxAffectedOwnTransaction(t *testing.T) {
+	db, server := newFakeDb(t)
+	server.affected = 5
+
+	_, err := db.NewQuery().Table("user").Where("status", 0).MaxAffected(2).Update(map[string]interface{}{"status": 1})
+	var safe SafeError
+	if !errors.As(err, &safe) {
+		t.Fatalf("err = %v, want SafeError", err)
+	}
+	want := []string{"BEGIN", "UPDATE user SET status = ? WHERE status = ?", "ROLLBACK"}
+	if got := statements(server); strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("executed %q, want %q", got, want)
+	}
+
+	server.affected = 2
+	if n, err := db.NewQuery().Table("user").Where("status", 0).MaxAffected(2).Delete(); err != nil || n != 2 {
+		t.Fatalf("delete = %d, %v", n, err)
+	}
+	if got := statements(server); got[len(got)-1] != "COMMIT" {
+		t.Fatalf("executed %q, want COMMIT", got)
+	}
+}
+
+func TestMaxAffectedCallerTransaction(t *testing.T) {
+	db, server := newFakeDb(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.NewQuery().Table("log").Insert(map[string]interface{}{"msg": "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.affected = 5
+	_, err = tx.NewQuery().Table("user").Where("status", 0).MaxAffected(2).Delete()
+	var safe SafeError
+	if !errors.As(err, &safe) {
+		t.Fatalf("err = %v, want SafeError", err)
+	}
+	server.affected = 1
+	if _, err := tx.NewQuery().Table("user").Where("id", 1).MaxAffected(2).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"INSERT INTO log  (msg) VALUES (?)",
+		"SAVEPOINT querydb_max_affected",
+		"DELETE  FROM user WHERE status = ?",
+		"ROLLBACK TO SAVEPOINT querydb_max_affected",
+		"SAVEPOINT querydb_max_affected",
+		"DELETE  FROM user WHERE id = ?",
+		"RELEASE SAVEPOINT querydb_max_affected",
+		"COMMIT",
+	}
+	if got := statements(server); strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("executed\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	db, server := newFakeDb(t)
+	db.link = &Config{SoftDeletes: map[string]string{"user": "deleted_at"}}
+	server.result("FROM user", []string{"id"})
+
+	if _, err := db.NewQuery().Table("user").Where("id", 1).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("user").Where("id", 1).Rows().ToInterface(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("user").WithTrashed().Rows().ToInterface(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("user").OnlyTrashed().Rows().ToInterface(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("user").Where("id", 1).Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("user").Where("id", 1).ForceDelete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("user u").LeftJoin("profile p", "p.user_id = u.id").Where("u.id", 1).Rows().ToInterface(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewQuery().Table("log").SoftDelete("removed_at").Where("id", 2).Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"UPDATE user SET deleted_at = ? WHERE deleted_at IS NULL AND (id = ?)",
+		"SELECT * FROM user WHERE deleted_at IS NULL AND (id = ?)",
+		"SELECT * FROM user",
+		"SELECT * FROM user WHERE deleted_at IS NOT NULL",
+		"UPDATE user SET deleted_at = ? WHERE deleted_at IS NOT NULL AND (id = ?)",
+		"DELETE  FROM user WHERE id = ?",
+		"SELECT * FROM user u LEFT JOIN profile p ON p.user_id = u.id WHERE u.deleted_at IS NULL AND (u.id = ?)",
+		"UPDATE log SET removed_at = ? WHERE removed_at IS NULL AND (id = ?)",
+	}
+	calls := server.executed()
+	if len(calls) != len(want) {
+		t.Fatalf("executed %q", statements(server))
+	}
+	for i, call := range calls {
+		if call.query != want[i] {
+			t.Errorf("sql[%d] = %q, want %q", i, call.query, want[i])
+		}
+	}
+	if deleted, ok := calls[0].args[0].(time.Time); !ok || deleted.IsZero() || calls[0].args[1] != int64(1) {
+		t.Errorf("delete args = %v", calls[0].args)
+	}
+	if args := calls[4].args; len(args) != 2 || args[0] != nil || args[1] != int64(1) {
+		t.Errorf("restore args = %v", args)
+	}
+}
@@ -0,0 +1,228 @@
+package querydb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//activity 连接池上执行中的语句及事务，QueryDb 的副本共用，连接池被替换后等待其完成再关闭
+type activity struct {
+	mu      sync.Mutex
+	active  int
+	retired bool
+	closed  bool //已关闭，不再接受新的语句及事务
+	idle    chan struct{}
+}
+
+//begin 开始执行语句或事务，连接已关闭时返回 ErrClosed
+func (a *activity) begin() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	a.active++
+	return nil
+}
+
+func (a *activity) end() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.active--
+	if a.retired && a.active == 0 && a.idle != nil {
+		close(a.idle)
+		a.idle = nil
+	}
+	a.mu.Unlock()
+}
+
+//retire 标记连接池已被替换，返回的 channel 在执行中的语句及事务都完成后关闭
+func (a *activity) retire() <-chan struct{} {
+	idle := make(chan struct{})
+	if a == nil {
+		close(idle)
+		return idle
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.idle != nil {
+		return a.idle
+	}
+	a.retired = true
+	if a.active <= 0 {
+		close(idle)
+	} else {
+		a.idle = idle
+	}
+	return idle
+}
+
+//stop 不再接受新的语句及事务，返回的 channel 在执行中的语句及事务都完成后关闭
+func (a *activity) stop() <-chan struct{} {
+	if a != nil {
+		a.mu.Lock()
+		a.closed = true
+		a.mu.Unlock()
+	}
+	return a.retire()
+}
+
+//Reload 用新的配置集合替换从文件加载的配置，配置有变化且已建立连接的先建立新连接，全部成功后再替换，
+//之后获取的连接使用新的连接池，旧的连接池在执行中的语句及事务完成后关闭
+//LoadEnv 的环境变量在新的配置上再次应用；之前从文件加载、新集合中没有的配置被移除，SetConfig 设置的配置保留
+//已调用 Close 或 Shutdown 时返回 ErrClosed
+func (configs *Configs) Reload(loaded map[string]*Config) error {
+	loaded, err := configs.withEnv(loaded)
+	if err != nil {
+		return err
+	}
+	configs.mu.RLock()
+	if configs.closed {
+		configs.mu.RUnlock()
+		return ErrClosed
+	}
+	changed := make(map[string]*Config)
+	var warm, removed []string
+	for name, config := range loaded {
+		if old, ok := configs.cfg[name]; ok && reflect.DeepEqual(old, config) {
+			continue
+		}
+		changed[name] = config
+		if _, ok := configs.connections[name]; ok {
+			warm = append(warm, name)
+		}
+	}
+	for name := range configs.fileConfigs {
+		if _, ok := loaded[name]; ok {
+			continue
+		}
+		if _, ok := configs.cfg[name]; ok {
+			removed = append(removed, name)
+		}
+	}
+	configs.mu.RUnlock()
+	sort.Strings(warm)
+	sort.Strings(removed)
+
+	opened := make(map[string]*sql.DB, len(warm))
+	for _, name := range warm {
+		db, err := connect(changed[name])
+		if err != nil {
+			for _, db := range opened {
+				db.Close()
+			}
+			return fmt.Errorf("reload %s: %w", name, err)
+		}
+		opened[name] = db
+	}
+
+	configs.mu.Lock()
+	if configs.closed {
+		//建立新连接期间已关闭
+		configs.mu.Unlock()
+		for _, db := range opened {
+			db.Close()
+		}
+		return ErrClosed
+	}
+	var retired []*QueryDb
+	for _, name := range removed {
+		delete(configs.cfg, name)
+	}
+	for name, config := range changed {
+		configs.cfg[name] = config
+	}
+	configs.fileConfigs = make(map[string]bool, len(loaded))
+	for name := range loaded {
+		configs.fileConfigs[name] = true
+	}
+	for _, name := range append(removed, sortedKeys(changed)...) {
+		//租户路由生成的配置按新配置重新生成
+		configs.dropTenantConfigs(name)
+		retired = append(retired, configs.dropConnections(name)...)
+	}
+	if configs.connections == nil {
+		configs.connections = make(map[string]*QueryDb)
+	}
+	for name, db := range opened {
+		configs.connections[name] = &QueryDb{db: db, name: name, link: changed[name], configs: configs, active: &activity{}}
+	}
+	configs.mu.Unlock()
+	drainConnections(retired)
+
+	if len(changed) > 0 || len(removed) > 0 {
+		Log.Info(fmt.Sprintf("reloaded configs, changed %v, removed %v", sortedKeys(changed), removed))
+	}
+	return nil
+}
+
+//ReloadFile 从文件重新加载配置，格式同 Load
+func (configs *Configs) ReloadFile(path string) error {
+	loaded, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	return configs.Reload(loaded)
+}
+
+//Watch 每隔 interval 检查配置文件，内容变化时按 Reload 重新加载，直到 ctx 结束或调用 Close、Shutdown
+//重新加载失败时记录日志并保留原配置，文件再次变化时重新加载
+func (configs *Configs) Watch(ctx context.Context, path string, interval time.Duration) error {
+	done := configs.closing()
+	select {
+	case <-done:
+		return ErrClosed
+	default:
+	}
+	last, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				Log.Warn(fmt.Sprintf("watch %s: %v", path, err))
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+			loaded, err := parseConfigs(bytes.NewReader(data), strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+			if err == nil {
+				err = configs.Reload(loaded)
+			}
+			if err != nil {
+				Log.Error(fmt.Sprintf("reload %s: %v", path, err))
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,128 @@
+package querydb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsEnvAndRuntimeConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("default:\n  host: 127.0.0.1\n  database: shop\nreport:\n  host: 127.0.0.2\n")
+
+	configs := Default()
+	defer configs.Close()
+	if err := configs.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("QDBTEST_DEFAULT_HOST", "10.0.0.1")
+	if err := configs.LoadEnv("QDBTEST"); err != nil {
+		t.Fatal(err)
+	}
+	configs.SetConfig("extra", &Config{Host: "10.0.0.9"})
+
+	write("default:\n  host: 127.0.0.1\n  database: shop_v2\n")
+	if err := configs.ReloadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	configs.mu.RLock()
+	defer configs.mu.RUnlock()
+	if c := configs.cfg["default"]; c == nil || c.Host != "10.0.0.1" || c.Database != "shop_v2" {
+		t.Fatalf("default = %+v, want env host and reloaded database", c)
+	}
+	if _, ok := configs.cfg["report"]; ok {
+		t.Fatal("report was not removed from the file and should be")
+	}
+	if _, ok := configs.cfg["extra"]; !ok {
+		t.Fatal("extra set by SetConfig was removed")
+	}
+	names := sortedKeys(configs.cfg)
+	if got := strings.Join(names, ","); got != "default,extra" {
+		t.Fatalf("configs = %s", got)
+	}
+}
+
+func TestReloadUnchangedWithEnv(t *testing.T) {
+	configs := Default()
+	defer configs.Close()
+	if err := configs.LoadReader(strings.NewReader(`{"default": {"host": "127.0.0.1"}}`), "json"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("QDBTEST_DEFAULT_DATABASE", "shop")
+	if err := configs.LoadEnv("QDBTEST"); err != nil {
+		t.Fatal(err)
+	}
+	configs.mu.RLock()
+	before := configs.cfg["default"]
+	configs.mu.RUnlock()
+
+	if err := configs.Reload(map[string]*Config{"default": {Host: "127.0.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	configs.mu.RLock()
+	after := configs.cfg["default"]
+	configs.mu.RUnlock()
+	if after != before {
+		t.Fatalf("config replaced with %+v although the env-applied result is unchanged", after)
+	}
+}
+
+func TestReloadAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.yaml")
+	if err := os.WriteFile(path, []byte("default:\n  host: 127.0.0.1\n  lazy: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configs := Default()
+	if err := configs.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configs.WriteE("default"); err != nil {
+		t.Fatal(err)
+	}
+	configs.Close()
+
+	if err := configs.Reload(map[string]*Config{"default": {Host: "127.0.0.2", Lazy: true}}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Reload err = %v, want ErrClosed", err)
+	}
+	if err := configs.ReloadFile(path); !errors.Is(err, ErrClosed) {
+		t.Fatalf("ReloadFile err = %v, want ErrClosed", err)
+	}
+	if err := configs.Watch(context.Background(), path, time.Millisecond); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Watch err = %v, want ErrClosed", err)
+	}
+	configs.mu.RLock()
+	defer configs.mu.RUnlock()
+	if len(configs.connections) != 0 || configs.cfg["default"].Host != "127.0.0.1" {
+		t.Fatalf("connections %v, config %+v after Close", configs.connections, configs.cfg["default"])
+	}
+}
+
+func TestWatchStopsOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.yaml")
+	if err := os.WriteFile(path, []byte("default:\n  host: 127.0.0.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configs := Default()
+	before := runtime.NumGoroutine()
+	if err := configs.Watch(context.Background(), path, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	configs.Close()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines = %d, want %d, Watch did not stop", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,410 @@
+package querydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+//ErrNoReplica 所有从库都不可用且不允许使用主库
+var ErrNoReplica = errors.New("no available replica")
+
+//ReplicaState 从库的状态，用于负载均衡选择从库
+type ReplicaState struct {
+	Index    int     //在 Config.Slave 中的下标
+	Config   *Config //从库配置
+	InFlight int64   //正在执行的语句数
+}
+
+//Balancer 从库负载均衡，从可用的从库中选择一个，返回 replicas 的下标
+type Balancer interface {
+	Pick(replicas []ReplicaState) int
+}
+
+//RandomBalancer 随机选择
+type RandomBalancer struct{}
+
+//Pick 实现 Balancer
+func (RandomBalancer) Pick(replicas []ReplicaState) int {
+	return rand.Intn(len(replicas))
+}
+
+//RoundRobinBalancer 轮询选择，需使用指针 &RoundRobinBalancer{}
+type RoundRobinBalancer struct {
+	next uint64
+}
+
+//Pick 实现 Balancer
+func (b *RoundRobinBalancer) Pick(replicas []ReplicaState) int {
+	return int((atomic.AddUint64(&b.next, 1) - 1) % uint64(len(replicas)))
+}
+
+//WeightedBalancer 按从库配置的 Weight 加权随机选择，Weight 小于 1 时按 1 计算
+type WeightedBalancer struct{}
+
+//Pick 实现 Balancer
+func (WeightedBalancer) Pick(replicas []ReplicaState) int {
+	total := 0
+	for _, r := range replicas {
+		total += r.Config.weight()
+	}
+	n := rand.Intn(total)
+	for i, r := range replicas {
+		if n -= r.Config.weight(); n < 0 {
+			return i
+		}
+	}
+	return len(replicas) - 1
+}
+
+//LeastInFlightBalancer 选择正在执行的语句数最少的从库，相同时随机
+type LeastInFlightBalancer struct{}
+
+//Pick 实现 Balancer
+func (LeastInFlightBalancer) Pick(replicas []ReplicaState) int {
+	best, n := 0, 0
+	for i, r := range replicas {
+		switch {
+		case r.InFlight < replicas[best].InFlight:
+			best, n = i, 1
+		case r.InFlight == replicas[best].InFlight:
+			//蓄水池抽样，相同的从库中随机选择
+			if n++; rand.Intn(n) == 0 {
+				best = i
+			}
+		}
+	}
+	return best
+}
+
+//NewBalancer 按名称获取负载均衡：random、round_robin、weighted、least_inflight
+func NewBalancer(name string) (Balancer, error) {
+	switch name {
+	case "", "random":
+		return RandomBalancer{}, nil
+	case "round_robin":
+		return &RoundRobinBalancer{}, nil
+	case "weighted":
+		return WeightedBalancer{}, nil
+	case "least_inflight":
+		return LeastInFlightBalancer{}, nil
+	}
+	return nil, fmt.Errorf("unknown balancer %q", name)
+}
+
+func (config *Config) weight() int {
+	if config.Weight < 1 {
+		return 1
+	}
+	return config.Weight
+}
+
+//replica 从库及其健康状态
+type replica struct {
+	index    int
+	key      string
+	config   *Config
+	pool     *replicaPool
+	inFlight int64
+	lag      int64 //复制延迟，time.Duration
+
+	mu        sync.Mutex
+	failures  int       //连续失败次数
+	ejections int       //连续被剔除的次数，用于计算退避时间
+	until     time.Time //剔除到期时间
+}
+
+//begin 开始执行语句
+func (r *replica) begin() {
+	if r != nil {
+		atomic.AddInt64(&r.inFlight, 1)
+	}
+}
+
+//end 语句执行完成，连接类错误计入失败次数
+func (r *replica) end(err error) {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.inFlight, -1)
+	if isConnError(err) {
+		r.fail(err)
+	} else if err == nil {
+		r.succeed()
+	}
+}
+
+//available 是否可用，剔除到期后重新参与选择，再次失败时剔除时间翻倍
+func (r *replica) available(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !now.Before(r.until)
+}
+
+//fail 记录失败，连续失败达到 MaxFailures 时剔除
+func (r *replica) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures++; r.failures >= r.pool.maxFailures() {
+		r.eject(err)
+	}
+}
+
+//eject 剔除从库，需持有锁
+func (r *replica) eject(err error) {
+	r.failures = 0
+	r.ejections++
+	backoff := r.pool.backoff(r.ejections)
+	r.until = time.Now().Add(backoff)
+	Log.Warn(fmt.Sprintf("replica %s:%s ejected for %s: %v", r.config.Host, r.config.Port, backoff, err))
+}
+
+//succeed 记录成功
+func (r *replica) succeed() {
+	r.mu.Lock()
+	r.failures = 0
+	if !time.Now().Before(r.until) {
+		r.ejections = 0
+	}
+	r.mu.Unlock()
+}
+
+//isConnError 是否为连接类错误，只有驱动的失效连接及网络错误影响从库的健康状态
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+//replicaPool 主库配置的从库集合
+type replicaPool struct {
+	name     string
+	config   *Config //主库配置
+	replicas []*replica
+	stop     chan struct{}
+	once     sync.Once
+}
+
+//newReplicaPool 创建从库集合，配置了 HealthCheckInterval 时定期检查从库
+func (configs *Configs) newReplicaPool(name string, config *Config) *replicaPool {
+	pool := &replicaPool{name: name, config: config, stop: make(chan struct{})}
+	for i, slave := range config.Slave {
+		pool.replicas = append(pool.replicas, &replica{index: i, key: name + "_read_" + strconv.Itoa(i), config: config.replicaConfig(slave), pool: pool})
+	}
+	if config.HealthCheckInterval > 0 {
+		go configs.healthCheck(pool)
+	}
+	if config.MaxLag > 0 {
+		go configs.lagProbe(pool)
+	}
+	return pool
+}
+
+//replicaConfig 从库的连接配置，未设置的账号及密码、数据库、字符编码、时区、超时、TLS 等连接参数沿用主库
+//ParseTime、InterpolateParams 主库开启时从库同样开启，Params 合并主库中从库未设置的参数
+func (config *Config) replicaConfig(slave *Config) *Config {
+	c := *slave
+	inherit := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	if c.Username == "" {
+		c.Username, c.Password = config.Username, config.Password
+	}
+	inherit(&c.Database, config.Database)
+	inherit(&c.Charset, config.Charset)
+	inherit(&c.Collation, config.Collation)
+	inherit(&c.TLS, config.TLS)
+	inherit(&c.TLSCAFile, config.TLSCAFile)
+	inherit(&c.TLSCertFile, config.TLSCertFile)
+	inherit(&c.TLSKeyFile, config.TLSKeyFile)
+	inherit(&c.TLSServerName, config.TLSServerName)
+	if c.Loc == nil {
+		c.Loc = config.Loc
+	}
+	if c.Timeout == 0 {
+		c.Timeout = config.Timeout
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = config.ReadTimeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = config.WriteTimeout
+	}
+	c.ParseTime = c.ParseTime || config.ParseTime
+	c.InterpolateParams = c.InterpolateParams || config.InterpolateParams
+	if len(config.Params) > 0 {
+		params := make(map[string]string, len(config.Params)+len(slave.Params))
+		for k, v := range config.Params {
+			params[k] = v
+		}
+		for k, v := range slave.Params {
+			params[k] = v
+		}
+		c.Params = params
+	}
+	return &c
+}
+
+//close 停止健康检查
+func (pool *replicaPool) close() {
+	pool.once.Do(func() {
+		close(pool.stop)
+	})
+}
+
+func (pool *replicaPool) maxFailures() int {
+	if pool.config.MaxFailures < 1 {
+		return 3
+	}
+	return pool.config.MaxFailures
+}
+
+//backoff 第 n 次剔除的时间，从 EjectBackoff 开始翻倍，最多 64 倍
+func (pool *replicaPool) backoff(n int) time.Duration {
+	base := pool.config.EjectBackoff
+	if base <= 0 {
+		base = time.Second
+	}
+	if n > 7 {
+		n = 7
+	}
+	return base << uint(n-1)
+}
+
+//balancer 获取负载均衡，默认随机
+func (pool *replicaPool) balancer() Balancer {
+	if pool.config.Balancer != nil {
+		return pool.config.Balancer
+	}
+	return RandomBalancer{}
+}
+
+//candidates 可用的从库，不包括被剔除及复制延迟超过 MaxLag 的从库
+func (pool *replicaPool) candidates() []*replica {
+	now := time.Now()
+	list := make([]*replica, 0, len(pool.replicas))
+	for _, r := range pool.replicas {
+		if r.available(now) && !r.lagging() {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+//pick 按负载均衡选择从库
+func (pool *replicaPool) pick(list []*replica) int {
+	states := make([]ReplicaState, len(list))
+	for i, r := range list {
+		states[i] = ReplicaState{Index: r.index, Config: r.config, InFlight: atomic.LoadInt64(&r.inFlight)}
+	}
+	i := pool.balancer().Pick(states)
+	if i < 0 || i >= len(list) {
+		i = 0
+	}
+	return i
+}
+
+//healthCheck 定期 Ping 已建立连接的从库
+func (configs *Configs) healthCheck(pool *replicaPool) {
+	interval := pool.config.HealthCheckInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, r := range pool.replicas {
+			configs.mu.RLock()
+			conn, ok := configs.connections[r.key]
+			configs.mu.RUnlock()
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := conn.db.PingContext(ctx)
+			cancel()
+			if err != nil {
+				r.fail(err)
+			} else {
+				r.succeed()
+			}
+		}
+	}
+}
+
+//replicaPool 获取主库配置的从库集合，已关闭时返回 ErrClosed
+func (configs *Configs) replicaPool(name string, config *Config) (*replicaPool, error) {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if configs.closed {
+		return nil, ErrClosed
+	}
+	if pool, ok := configs.pools[name]; ok && pool.config == config {
+		return pool, nil
+	} else if ok {
+		pool.close()
+	}
+	if configs.pools == nil {
+		configs.pools = make(map[string]*replicaPool)
+	}
+	pool := configs.newReplicaPool(name, config)
+	configs.pools[name] = pool
+	return pool, nil
+}
+
+//readReplica 选择可用的从库并建立连接，连接失败时剔除并选择其它从库，都不可用时按配置使用主库
+func (configs *Configs) readReplica(name string, config *Config) (*QueryDb, error) {
+	pool, err := configs.replicaPool(name, config)
+	if err != nil {
+		return nil, err
+	}
+	list := pool.candidates()
+	var lastErr error
+	for len(list) > 0 {
+		i := pool.pick(list)
+		r := list[i]
+		conn, err := configs.connection(r.key, r.config)
+		if err == nil {
+			q := *conn
+			q.replica = r
+			//查询构造器的选项（软删除、安全模式、解码方式等）沿用主库配置，从库配置只用于建立连接
+			q.link = config
+			return &q, nil
+		}
+		if errors.Is(err, ErrClosed) {
+			return nil, err
+		}
+		lastErr = err
+		//建立连接失败直接剔除
+		r.mu.Lock()
+		r.eject(err)
+		r.mu.Unlock()
+		list = append(list[:i], list[i+1:]...)
+	}
+	if config.NoMasterFallback {
+		if lastErr != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrNoReplica, name, lastErr)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNoReplica, name)
+	}
+	Log.Warn("all replicas of " + name + " are unavailable, reading from master")
+	return configs.connection(name, config)
+}
@@ -0,0 +1,97 @@
+package querydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsConnError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", mysql.ErrInvalidConn), true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{&mysql.MySQLError{Number: 1064, Message: "syntax error"}, false},
+		{sql.ErrNoRows, false},
+		{context.Canceled, false},
+		{errors.New("sql: Scan error on column index 0"), false},
+		{ErrNoTenant, false},
+	}
+	for _, c := range cases {
+		if got := isConnError(c.err); got != c.want {
+			t.Errorf("isConnError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestReplicaInheritsMasterConnection(t *testing.T) {
+	configs := Default()
+	defer configs.Close()
+	master := &Config{
+		Username: "app", Password: "secret", Host: "10.0.0.1", Database: "shop", Charset: "utf8mb4", Lazy: true,
+		TLS: "skip-verify", ParseTime: true, Loc: time.UTC, Timeout: 3 * time.Second, ReadTimeout: time.Second,
+		Params: map[string]string{"sql_mode": "'STRICT_ALL_TABLES'", "wait_timeout": "60"},
+	}
+	master.SetSlave(&Config{Host: "10.0.0.2", Lazy: true})
+	master.SetSlave(&Config{Username: "reader", Host: "10.0.0.3", Database: "shop_ro", Timeout: time.Second, Params: map[string]string{"wait_timeout": "30"}, Lazy: true})
+	configs.SetConfig("default", master)
+
+	pool, err := configs.replicaPool("default", master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Config{
+		{Username: "app", Password: "secret", Host: "10.0.0.2", Database: "shop", Charset: "utf8mb4", Lazy: true,
+			TLS: "skip-verify", ParseTime: true, Loc: time.UTC, Timeout: 3 * time.Second, ReadTimeout: time.Second,
+			Params: map[string]string{"sql_mode": "'STRICT_ALL_TABLES'", "wait_timeout": "60"}},
+		{Username: "reader", Host: "10.0.0.3", Database: "shop_ro", Charset: "utf8mb4", Lazy: true,
+			TLS: "skip-verify", ParseTime: true, Loc: time.UTC, Timeout: time.Second, ReadTimeout: time.Second,
+			Params: map[string]string{"sql_mode": "'STRICT_ALL_TABLES'", "wait_timeout": "30"}},
+	}
+	for i, r := range pool.replicas {
+		if !reflect.DeepEqual(*r.config, want[i]) {
+			t.Errorf("replica %d config = %+v, want %+v", i, *r.config, want[i])
+		}
+	}
+	if master.Slave[0].Username != "" || master.Slave[1].Params["sql_mode"] != "" {
+		t.Fatal("slave config was modified")
+	}
+}
+
+func TestReplicaUsesMasterOptions(t *testing.T) {
+	configs := Default()
+	defer configs.Close()
+	master := &Config{Host: "10.0.0.1", SoftDeletes: map[string]string{"user": "deleted_at"}, Lazy: true}
+	master.SetSlave(&Config{Host: "10.0.0.2", Lazy: true})
+	configs.SetConfig("default", master)
+
+	server, db := newFakeServer(t)
+	server.result("FROM user", []string{"id"})
+	configs.mu.Lock()
+	configs.connections["default_read_0"] = &QueryDb{db: db, name: "default_read_0", link: master.replicaConfig(master.Slave[0]), configs: configs, active: &activity{}}
+	configs.mu.Unlock()
+
+	replica, err := configs.ReadE("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.NewQuery().Table("user").Where("id", 1).Rows().ToInterface(); err != nil {
+		t.Fatal(err)
+	}
+	calls := server.executed()
+	if len(calls) != 1 || calls[0].query != "SELECT * FROM user WHERE deleted_at IS NULL AND (id = ?)" {
+		t.Fatalf("executed %v", calls)
+	}
+}
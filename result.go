@@ -8,7 +8,10 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
-// Row 获取记录
+//ErrNoRows Row 没有查询到记录，与 sql.ErrNoRows 相同，Rows 没有记录时返回空切片
+var ErrNoRows = sql.ErrNoRows
+
+// Row 获取记录，没有记录时返回 ErrNoRows
 type Row struct {
 	rs          *Rows
 	lastError   error
@@ -17,356 +20,523 @@ type Row struct {
 
 //ToArray get Array
 func (r *Row) ToArray() (result []string, err error) {
-	items, err := r.rs.ToArray()
-	if err != nil {
-		r.lastError = err
+	items, err := r.rs.toArray(1)
+	if err = r.first(len(items), err); err != nil {
 		return nil, err
 	}
-	if len(items) > 0 {
-		return items[0], nil
+	return items[0], nil
+}
+
+//ToArrayNull get Array，NULL 返回 nil
+func (r *Row) ToArrayNull() (result []*string, err error) {
+	items, err := r.rs.toArrayNull(1)
+	if err = r.first(len(items), err); err != nil {
+		return nil, err
 	}
-	return nil, sql.ErrNoRows
+	return items[0], nil
 }
 
 //ToMap get Map
 func (r *Row) ToMap() (result map[string]string, err error) {
-	items, err := r.rs.ToMap()
-	if err != nil {
-		r.lastError = err
+	items, err := r.rs.toMap(1)
+	if err = r.first(len(items), err); err != nil {
 		return nil, err
 	}
-	if len(items) > 0 {
-		return items[0], nil
-	}
-	return nil, sql.ErrNoRows
+	return items[0], nil
 }
 
-func (r *Row) ToInterface() (result map[string]interface{}, err error) {
-	items, err := r.rs.ToInterface()
-
-	if err != nil {
-		r.lastError = err
+//ToMapNull get Map，NULL 返回 nil
+func (r *Row) ToMapNull() (result map[string]*string, err error) {
+	items, err := r.rs.toMapNull(1)
+	if err = r.first(len(items), err); err != nil {
 		return nil, err
 	}
+	return items[0], nil
+}
 
-	if len(items) > 0 {
-		return items[0], nil
+//ToInterface get map[string]interface{}，按字段类型返回
+func (r *Row) ToInterface() (result map[string]interface{}, err error) {
+	items, err := r.rs.toInterface(1)
+	if err = r.first(len(items), err); err != nil {
+		return nil, err
 	}
-	return nil, sql.ErrNoRows
+	return items[0], nil
 }
 
 //ToStruct get Struct
 func (r *Row) ToStruct(st interface{}) error {
 	//获取变量的类型
 	stType := reflect.TypeOf(st)
+	if stType == nil || stType.Kind() != reflect.Ptr {
+		return r.rs.discard(fmt.Errorf("the variable type is %v, not a pointer", reflect.ValueOf(st).Kind()))
+	}
+	stTypeInd := stType.Elem()
+	if stTypeInd.Kind() != reflect.Struct {
+		return r.rs.discard(fmt.Errorf("the variable type is %v, not a struct", stTypeInd.Kind()))
+	}
 
-	stVal := reflect.ValueOf(st)
+	return r.Scan(st)
+}
 
-	if stType.Kind() != reflect.Ptr {
-		return fmt.Errorf("the variable type is %v, not a pointer", stType.Kind())
+//Scan 扫描一条记录，dest 为 *Struct 或单个字段的 *int64、*string 等，传入多个时按字段顺序扫描
+func (r *Row) Scan(dest ...interface{}) error {
+	if len(dest) == 1 {
+		v := reflect.ValueOf(dest[0])
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return r.rs.discard(fmt.Errorf("the variable type is %T, not a pointer", dest[0]))
+		}
+		items := reflect.New(reflect.SliceOf(v.Elem().Type())).Elem()
+		err := r.rs.scanSlice(items, 1)
+		if err = r.first(items.Len(), err); err != nil {
+			return err
+		}
+		v.Elem().Set(items.Index(0))
+		return nil
 	}
 
-	stTypeInd := stType.Elem()
+	n := 0
+	err := r.rs.each(1, func(fields []string) error {
+		if len(fields) != len(dest) {
+			return fmt.Errorf("expected %d destination arguments in Scan, got %d columns", len(dest), len(fields))
+		}
+		return nil
+	}, func() ([]interface{}, error) {
+		refs := make([]interface{}, len(dest))
+		for i, d := range dest {
+			refs[i] = d
+			if v := reflect.ValueOf(d); v.Kind() == reflect.Ptr && !v.IsNil() {
+				refs[i] = r.rs.decode.scanDest(v.Elem())
+			}
+		}
+		return refs, nil
+	}, func() error {
+		n++
+		return nil
+	})
+	return r.first(n, err)
+}
 
-	if r.rs.rs == nil {
-		return r.lastError
+//first 检查单条记录的结果
+func (r *Row) first(n int, err error) error {
+	if err == nil && n < 1 {
+		err = ErrNoRows
 	}
-	// if r.transaction {
-	defer r.rs.rs.Close()
-	// }
-	v := reflect.New(stTypeInd)
+	r.lastError = err
+	return err
+}
 
-	tagList, err := extractTagInfo(v)
-	if err != nil {
-		return err
-	}
+//Rows get data，没有记录时返回空切片
+type Rows struct {
+	rs        *sql.Rows
+	lastError error
+	decode    decodeOptions
+}
 
-	fields, err := r.rs.rs.Columns()
+//ToArray get Array
+func (r *Rows) ToArray() (data [][]string, err error) {
+	return r.toArray(0)
+}
 
-	if err != nil {
-		r.rs.lastError = err
-		return err
-	}
+//ToArrayNull get Array，NULL 返回 nil
+func (r *Rows) ToArrayNull() (data [][]*string, err error) {
+	return r.toArrayNull(0)
+}
 
-	refs := make([]interface{}, len(fields))
+//ToMap get Map
+func (r *Rows) ToMap() (data []map[string]string, err error) {
+	return r.toMap(0)
+}
 
-	for i, field := range fields {
-		if f, ok := tagList[field]; ok {
-			refs[i] = f.Addr().Interface()
-		} else {
-			refs[i] = new(interface{})
-		}
+//ToMapNull get Map，NULL 返回 nil
+func (r *Rows) ToMapNull() (data []map[string]*string, err error) {
+	return r.toMapNull(0)
+}
+
+// ToInterface []map[string]interface{}，按字段类型返回 int64、uint64、float64、string、[]byte、time.Time，NULL 返回 nil
+func (r *Rows) ToInterface() (data []map[string]interface{}, err error) {
+	return r.toInterface(0)
+}
+
+//ToStruct get Struct
+func (r *Rows) ToStruct(st interface{}) error {
+	//st->&[]user
+	//获取变量的类型,类型为指针
+	stType := reflect.TypeOf(st)
+
+	//1.参数必须是指针
+	if stType == nil || stType.Kind() != reflect.Ptr {
+		return r.discard(fmt.Errorf("the variable type is %v, not a pointer", reflect.ValueOf(st).Kind()))
 	}
 
-	if !r.rs.rs.Next() {
-		return sql.ErrNoRows
+	//指针指向的类型:slice
+	stTypeInd := stType.Elem()
+	//2.传入的类型必须是slice,slice的成员类型必须是struct
+	if stTypeInd.Kind() != reflect.Slice || derefType(stTypeInd.Elem()).Kind() != reflect.Struct {
+		return r.discard(fmt.Errorf("the variable type is %v, not a slice struct", stType.Elem().Kind()))
 	}
+	return r.Scan(st)
+}
 
-	if err := r.rs.rs.Scan(refs...); err != nil {
+//Scan 扫描到 *[]Struct、*[]*Struct，或者单个字段的 *[]int64、*[]string 等
+func (r *Rows) Scan(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return r.discard(fmt.Errorf("the variable type is %T, not a pointer to slice", dest))
+	}
+	items := reflect.New(v.Elem().Type()).Elem()
+	items.Set(reflect.MakeSlice(items.Type(), 0, 0))
+	if err := r.scanSlice(items, 0); err != nil {
 		return err
 	}
-
-	stVal.Elem().Set(v.Elem())
-
+	v.Elem().Set(items)
 	return nil
 }
 
-//Rows get data
-type Rows struct {
-	rs        *sql.Rows
-	lastError error
+//ScanMap 以 column 字段的值为键扫描到 *map[K]Struct 或 *map[K]*Struct
+func (r *Rows) ScanMap(dest interface{}, column string) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Map {
+		return r.discard(fmt.Errorf("the variable type is %T, not a pointer to map", dest))
+	}
+	if derefType(v.Elem().Type().Elem()).Kind() != reflect.Struct {
+		return r.discard(fmt.Errorf("the variable type is %T, not a map of struct", dest))
+	}
+	items := reflect.New(v.Elem().Type()).Elem()
+	items.Set(reflect.MakeMap(items.Type()))
+	if err := r.scanMap(items, column); err != nil {
+		return err
+	}
+	v.Elem().Set(items)
+	return nil
 }
 
-//ToArray get Array
-func (r *Rows) ToArray() (data [][]string, err error) {
+//discard 开始读取前出错时关闭结果集，避免连接不能归还连接池
+func (r *Rows) discard(err error) error {
+	if r.rs != nil {
+		r.rs.Close()
+	}
+	return err
+}
 
+//each 遍历结果集，prepare 处理查询字段，dest 返回每一行 Scan 的目标，fn 处理扫描后的行，limit 大于 0 时最多处理 limit 行
+func (r *Rows) each(limit int, prepare func(fields []string) error, dest func() ([]interface{}, error), fn func() error) error {
 	if r.rs == nil {
-		return nil, r.lastError
+		return r.lastError
 	}
-
-	// if r.transaction {
 	defer r.rs.Close()
-	// }
 
 	//获取查询的字段
 	fields, err := r.rs.Columns()
-
 	if err != nil {
 		r.lastError = err
-		return nil, err
+		return err
 	}
-
-	data = make([][]string, 0)
-
-	num := len(fields)
-
-	//根据查询字段的数量，生成[num]interface{}用于存储Scan的结果
-	refs := make([]interface{}, num)
-	for i := 0; i < num; i++ {
-		var ref interface{}
-		refs[i] = &ref
+	if err := prepare(fields); err != nil {
+		r.lastError = err
+		return err
 	}
 
-	if !r.rs.Next() {
-		if err := r.rs.Err(); err != nil {
-			return nil, err
+	for n := 0; r.rs.Next(); n++ {
+		if limit > 0 && n >= limit {
+			break
 		}
-		return nil, sql.ErrNoRows
-	}
-
-	for r.rs.Next() {
-
-		result := make([]string, len(fields))
-
-		if err := r.rs.Scan(refs...); err != nil {
-			return nil, err
+		refs, err := dest()
+		if err == nil {
+			err = r.rs.Scan(refs...)
 		}
-
-		for i := range fields {
-			//把*interface{}转换成strings返回
-			if val, err := toString(refs[i]); err == nil {
-				result[i] = val
-			} else {
-				return nil, err
-			}
+		if err == nil {
+			err = fn()
 		}
-
 		if err != nil {
 			r.lastError = err
-			return nil, err
+			return err
 		}
-
-		data = append(data, result)
 	}
-
-	if len(data) < 1 {
-		return nil, sql.ErrNoRows
+	if err := r.rs.Err(); err != nil {
+		r.lastError = err
+		return err
 	}
-
-	return data, nil
+	return nil
 }
 
-// ToInterface []map[interface{}]interface{}
-func (r *Rows) ToInterface() (data []map[string]interface{}, err error) {
-	if r.rs == nil {
-		return nil, r.lastError
-	}
-	// if r.transaction {
-	defer r.rs.Close()
-	// }
-	fields, err := r.rs.Columns()
-
-	if err != nil {
-		r.lastError = err
-		return nil, err
+//toString 转换成字符串，NULL 使用占位字符串
+func (r *Rows) toString(src interface{}) (string, error) {
+	val, err := toNullString(src)
+	if err != nil || val == nil {
+		return r.decode.null, err
 	}
-	data = make([]map[string]interface{}, 0)
-
-	num := len(fields)
-
-	refs := make([]interface{}, num)
+	return *val, nil
+}
 
-	for i := 0; i < num; i++ {
+//rawRefs 根据查询字段的数量，生成[num]*interface{}用于存储Scan的结果
+func rawRefs(fields []string) []interface{} {
+	refs := make([]interface{}, len(fields))
+	for i := range refs {
 		var ref interface{}
 		refs[i] = &ref
 	}
+	return refs
+}
 
-	// if !r.rs.Next() {
-	// 	if err := r.rs.Err(); err != nil {
-	// 		return nil, err
-	// 	}
-	// 	return nil, sql.ErrNoRows
-	// }
-
-	for r.rs.Next() {
-		result := make(map[string]interface{})
-		if err := r.rs.Scan(refs...); err != nil {
-			return nil, err
-		}
-		for i, field := range fields {
-			if val, err := toString(refs[i]); err == nil {
-				result[field] = interface{}(val)
-			} else {
-				return nil, err
+func (r *Rows) toArray(limit int) (data [][]string, err error) {
+	data = make([][]string, 0)
+	var refs []interface{}
+	err = r.each(limit, func(fields []string) error {
+		refs = rawRefs(fields)
+		return nil
+	}, func() ([]interface{}, error) {
+		return refs, nil
+	}, func() error {
+		result := make([]string, len(refs))
+		for i := range refs {
+			//把*interface{}转换成strings返回
+			val, err := r.toString(refs[i])
+			if err != nil {
+				return err
 			}
+			result[i] = val
 		}
 		data = append(data, result)
-	}
-	if len(data) < 1 {
-		return nil, sql.ErrNoRows
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return data, nil
-
 }
 
-//ToMap get Map
-func (r *Rows) ToMap() (data []map[string]string, err error) {
-	if r.rs == nil {
-		return nil, r.lastError
-	}
-	// if r.transaction {
-	defer r.rs.Close()
-	// }
-
-	fields, err := r.rs.Columns()
-
+func (r *Rows) toArrayNull(limit int) (data [][]*string, err error) {
+	data = make([][]*string, 0)
+	var refs []interface{}
+	err = r.each(limit, func(fields []string) error {
+		refs = rawRefs(fields)
+		return nil
+	}, func() ([]interface{}, error) {
+		return refs, nil
+	}, func() error {
+		result := make([]*string, len(refs))
+		for i := range refs {
+			val, err := toNullString(refs[i])
+			if err != nil {
+				return err
+			}
+			result[i] = val
+		}
+		data = append(data, result)
+		return nil
+	})
 	if err != nil {
-		r.lastError = err
 		return nil, err
 	}
+	return data, nil
+}
 
+func (r *Rows) toMap(limit int) (data []map[string]string, err error) {
 	data = make([]map[string]string, 0)
-	num := len(fields)
-
-	refs := make([]interface{}, num)
-
-	for i := 0; i < num; i++ {
-		var ref interface{}
-		refs[i] = &ref
-	}
-
-	// if !r.rs.Next() {
-	// 	if err := r.rs.Err(); err != nil {
-	// 		return nil, err
-	// 	}
-	// 	return nil, sql.ErrNoRows
-	// }
-
-	for r.rs.Next() {
-		result := make(map[string]string)
-		if err := r.rs.Scan(refs...); err != nil {
-			return nil, err
-		}
-
-		for i, field := range fields {
-			if val, err := toString(refs[i]); err == nil {
-				result[field] = val
-			} else {
-				return nil, err
+	var refs []interface{}
+	var columns []string
+	err = r.each(limit, func(fields []string) error {
+		columns = fields
+		refs = rawRefs(fields)
+		return nil
+	}, func() ([]interface{}, error) {
+		return refs, nil
+	}, func() error {
+		result := make(map[string]string, len(columns))
+		for i, field := range columns {
+			val, err := r.toString(refs[i])
+			if err != nil {
+				return err
 			}
+			result[field] = val
 		}
-
 		data = append(data, result)
-
-	}
-	if len(data) < 1 {
-		return nil, sql.ErrNoRows
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return data, nil
 }
 
-//ToStruct get Struct
-func (r *Rows) ToStruct(st interface{}) error {
-	//st->&[]user
-	//获取变量的类型,类型为指针
-	stType := reflect.TypeOf(st)
-
-	//获取变量的值
-	stVal := reflect.ValueOf(st)
-	stValInd := reflect.Indirect(stVal)
-
-	//1.参数必须是指针
-	if stType.Kind() != reflect.Ptr {
-		return fmt.Errorf("the variable type is %v, not a pointer", stType.Kind())
-	}
-
-	//指针指向的类型:slice
-	stTypeInd := stType.Elem()
-	//2.传入的类型必须是slice,slice的成员类型必须是struct
-	if stTypeInd.Kind() != reflect.Slice || stTypeInd.Elem().Kind() != reflect.Struct {
-		return fmt.Errorf("the variable type is %v, not a slice struct", stType.Elem().Kind())
-	}
-
-	if r.rs == nil {
-		return r.lastError
-	}
-	// if r.transaction {
-	defer r.rs.Close()
-	// }
-
-	//初始化struct
-	v := reflect.New(stTypeInd.Elem())
-
-	//提取结构体中的tag
-	tagList, err := extractTagInfo(v)
+func (r *Rows) toMapNull(limit int) (data []map[string]*string, err error) {
+	data = make([]map[string]*string, 0)
+	var refs []interface{}
+	var columns []string
+	err = r.each(limit, func(fields []string) error {
+		columns = fields
+		refs = rawRefs(fields)
+		return nil
+	}, func() ([]interface{}, error) {
+		return refs, nil
+	}, func() error {
+		result := make(map[string]*string, len(columns))
+		for i, field := range columns {
+			val, err := toNullString(refs[i])
+			if err != nil {
+				return err
+			}
+			result[field] = val
+		}
+		data = append(data, result)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return data, nil
+}
 
-	fields, err := r.rs.Columns()
-
+func (r *Rows) toInterface(limit int) (data []map[string]interface{}, err error) {
+	data = make([]map[string]interface{}, 0)
+	var refs []interface{}
+	var columns []string
+	var decoders []columnDecoder
+	err = r.each(limit, func(fields []string) error {
+		types, err := r.rs.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		columns = fields
+		decoders = newColumnDecoders(types, r.decode)
+		refs = rawRefs(fields)
+		return nil
+	}, func() ([]interface{}, error) {
+		return refs, nil
+	}, func() error {
+		result := make(map[string]interface{}, len(columns))
+		for i, field := range columns {
+			val, err := decoders[i].decode(*refs[i].(*interface{}))
+			if err != nil {
+				return err
+			}
+			result[field] = val
+		}
+		data = append(data, result)
+		return nil
+	})
 	if err != nil {
-		r.lastError = err
-		return err
+		return nil, err
 	}
+	return data, nil
+}
 
-	refs := make([]interface{}, len(fields))
+//scanSlice 将每一行追加到 items，items 为可设置的切片
+//元素为结构体或结构体指针时按字段映射，否则要求查询结果只有一个字段
+func (r *Rows) scanSlice(items reflect.Value, limit int) error {
+	return r.scanEach(items.Type().Elem(), limit, func(v reflect.Value) error {
+		items.Set(reflect.Append(items, v))
+		return nil
+	})
+}
 
-	for i, field := range fields {
-		//如果对应的字段在结构体中有映射，则使用结构体成员变量的地址
-		if f, ok := tagList[field]; ok {
-			refs[i] = f.Addr().Interface()
-		} else {
-			refs[i] = new(interface{})
+//scanEach 将每一行扫描为 elem 类型的值并调用 fn
+func (r *Rows) scanEach(elem reflect.Type, limit int, fn func(v reflect.Value) error) error {
+	base := derefType(elem)
+	if base.Kind() != reflect.Struct || isScalarStruct(base) {
+		var v reflect.Value
+		return r.each(limit, func(fields []string) error {
+			if len(fields) != 1 {
+				return fmt.Errorf("scan into %v requires 1 column, got %d", elem, len(fields))
+			}
+			return nil
+		}, func() ([]interface{}, error) {
+			v = reflect.New(elem)
+			return []interface{}{r.decode.scanDest(v.Elem())}, nil
+		}, func() error {
+			return fn(v.Elem())
+		})
+	}
+
+	var scanner *structScanner
+	var v reflect.Value
+	return r.each(limit, func(fields []string) error {
+		//在 each 中获取结构体信息，出错时同样关闭结果集
+		info, err := cachedStructInfo(base)
+		if err != nil {
+			return err
 		}
-	}
-
-	// if !r.rs.Next() {
-	// 	if err := r.rs.Err(); err != nil {
-	// 		return err
-	// 	}
-	// 	return sql.ErrNoRows
-	// }
+		scanner = info.scanner(fields, r.decode)
+		if r.decode.strict {
+			return scanner.check(base, fields, -1)
+		}
+		return nil
+	}, func() ([]interface{}, error) {
+		//每一行使用新的结构体，避免指针成员在多行之间共享
+		v = reflect.New(base)
+		return scanner.dest(v.Elem()), nil
+	}, func() error {
+		scanner.finish(v.Elem())
+		if elem.Kind() == reflect.Ptr {
+			return fn(v)
+		}
+		return fn(v.Elem())
+	})
+}
 
-	for r.rs.Next() {
-		if err := r.rs.Scan(refs...); err != nil {
+//scanMap 将每一行以 column 字段的值为键写入 items，items 为可设置的 map，值为结构体或结构体指针
+func (r *Rows) scanMap(items reflect.Value, column string) error {
+	elem := items.Type().Elem()
+	base := derefType(elem)
+	var scanner *structScanner
+	var v, key reflect.Value
+	keyIndex := -1
+	return r.each(0, func(fields []string) error {
+		info, err := cachedStructInfo(base)
+		if err != nil {
 			return err
 		}
-		stValInd = reflect.Append(stValInd, v.Elem())
-	}
+		for i, field := range fields {
+			if field == column {
+				keyIndex = i
+			}
+		}
+		if keyIndex < 0 {
+			return fmt.Errorf("key column %s not found in result", column)
+		}
+		scanner = info.scanner(fields, r.decode)
+		if r.decode.strict {
+			return scanner.check(base, fields, keyIndex)
+		}
+		return nil
+	}, func() ([]interface{}, error) {
+		v = reflect.New(base)
+		key = reflect.New(items.Type().Key()).Elem()
+		refs := scanner.dest(v.Elem())
+		refs[keyIndex] = &keyScanner{key: key, dest: refs[keyIndex]}
+		return refs, nil
+	}, func() error {
+		scanner.finish(v.Elem())
+		if elem.Kind() == reflect.Ptr {
+			items.SetMapIndex(key, v)
+		} else {
+			items.SetMapIndex(key, v.Elem())
+		}
+		return nil
+	})
+}
 
-	stVal.Elem().Set(stValInd)
+//keyScanner 扫描 map 的键，同时写入原来的目标
+type keyScanner struct {
+	key  reflect.Value
+	dest interface{}
+}
 
-	return nil
+//Scan 实现 sql.Scanner
+func (s *keyScanner) Scan(src interface{}) error {
+	if err := convertAssign(s.key, src); err != nil {
+		return err
+	}
+	if scanner, ok := s.dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+	return convertAssign(reflect.ValueOf(s.dest).Elem(), src)
+}
 
+//derefType 获取指针指向的类型
+func derefType(typ reflect.Type) reflect.Type {
+	if typ.Kind() == reflect.Ptr {
+		return typ.Elem()
+	}
+	return typ
 }
@@ -0,0 +1,130 @@
+package querydb
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type resultUser struct {
+	Id   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestRowsToArrayKeepsFirstRow(t *testing.T) {
+	db, server := newFakeDb(t)
+	server.result("FROM user", []string{"id", "name"}, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"})
+
+	data, err := db.NewQuery().Table("user").Rows().ToArray()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"1", "a"}, {"2", "b"}}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("ToArray = %v, want %v", data, want)
+	}
+
+	var users []resultUser
+	if err := db.NewQuery().Table("user").Rows().ToStruct(&users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0] != (resultUser{1, "a"}) {
+		t.Fatalf("ToStruct = %v", users)
+	}
+}
+
+func TestRowsEmptyReturnsEmptySlice(t *testing.T) {
+	db, server := newFakeDb(t)
+	server.result("FROM user", []string{"id", "name"})
+	server.result("FROM ids", []string{"id"})
+	rows := func() *Rows {
+		return db.NewQuery().Table("user").Rows()
+	}
+
+	array, err := rows().ToArray()
+	check(t, "ToArray", array, err)
+	arrayNull, err := rows().ToArrayNull()
+	check(t, "ToArrayNull", arrayNull, err)
+	maps, err := rows().ToMap()
+	check(t, "ToMap", maps, err)
+	mapsNull, err := rows().ToMapNull()
+	check(t, "ToMapNull", mapsNull, err)
+	values, err := rows().ToInterface()
+	check(t, "ToInterface", values, err)
+	var users []resultUser
+	err = rows().ToStruct(&users)
+	check(t, "ToStruct", users, err)
+	var ids []int64
+	err = db.NewQuery().Table("ids").Rows().Scan(&ids)
+	check(t, "Scan", ids, err)
+}
+
+//check 检查 Rows 的结果为空切片且没有错误
+func check(t *testing.T, name string, data interface{}, err error) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("%s err = %v, want nil", name, err)
+		return
+	}
+	v := reflect.ValueOf(data)
+	if v.IsNil() || v.Len() != 0 {
+		t.Errorf("%s = %#v, want empty slice", name, data)
+	}
+}
+
+func TestRowNoRows(t *testing.T) {
+	db, server := newFakeDb(t)
+	server.result("FROM user", []string{"id", "name"})
+	server.result("FROM ids", []string{"id"})
+	row := func() *Row {
+		return db.NewQuery().Table("user").Row()
+	}
+
+	var user resultUser
+	var id int64
+	var name string
+	decoders := map[string]func() error{
+		"ToArray":     func() error { _, err := row().ToArray(); return err },
+		"ToArrayNull": func() error { _, err := row().ToArrayNull(); return err },
+		"ToMap":       func() error { _, err := row().ToMap(); return err },
+		"ToMapNull":   func() error { _, err := row().ToMapNull(); return err },
+		"ToInterface": func() error { _, err := row().ToInterface(); return err },
+		"ToStruct":    func() error { return row().ToStruct(&user) },
+		"Scan":        func() error { return db.NewQuery().Table("ids").Row().Scan(&id) },
+		"ScanMulti":   func() error { return row().Scan(&id, &name) },
+	}
+	for name, decode := range decoders {
+		if err := decode(); !errors.Is(err, ErrNoRows) {
+			t.Errorf("%s err = %v, want ErrNoRows", name, err)
+		}
+	}
+}
+
+func TestRowsClosedOnEarlyError(t *testing.T) {
+	db, server := newFakeDb(t)
+	server.result("FROM user", []string{"id", "name"}, []driver.Value{int64(1), "a"})
+	type duplicated struct {
+		Id  int64 `db:"id"`
+		Key int64 `db:"id"`
+	}
+
+	var rows []duplicated
+	if err := db.NewQuery().Table("user").Rows().ToStruct(&rows); err == nil {
+		t.Fatal("ToStruct with duplicated tags succeeded")
+	}
+	var byId map[int64]duplicated
+	if err := db.NewQuery().Table("user").Rows().ScanMap(&byId, "id"); err == nil {
+		t.Fatal("ScanMap with duplicated tags succeeded")
+	}
+	var row duplicated
+	if err := db.NewQuery().Table("user").Row().ToStruct(&row); err == nil {
+		t.Fatal("Row.ToStruct with duplicated tags succeeded")
+	}
+	if err := db.NewQuery().Table("user").Rows().Scan(rows); err == nil {
+		t.Fatal("Scan into a non-pointer succeeded")
+	}
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatalf("InUse = %d, rows were not closed", inUse)
+	}
+}
@@ -0,0 +1,141 @@
+package querydb
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+//RoutedDb 读写分离的连接，不加锁的 SELECT 在从库执行，其它语句及事务在主库执行
+type RoutedDb struct {
+	configs *Configs
+	name    string
+	ctx     context.Context
+	master  bool //全部在主库执行
+	lastsql Sql
+}
+
+//Router 获取读写分离的连接
+//	db := configs.Router("default")
+//	db.NewQuery().Table("user").Rows()               //从库
+//	db.NewQuery().Table("user").Insert(data)          //主库
+//	db.NewQuery().Table("user").ForceMaster().Rows() //主库
+func (configs *Configs) Router(name string) *RoutedDb {
+	return &RoutedDb{configs: configs, name: name}
+}
+
+//WithContext 返回绑定 context 的连接，按 context 中的租户路由，会话中写入后的读取使用主库
+func (routed *RoutedDb) WithContext(ctx context.Context) *RoutedDb {
+	r := *routed
+	r.lastsql = Sql{}
+	r.ctx = ctx
+	return &r
+}
+
+//ForceMaster 返回全部在主库执行的连接
+func (routed *RoutedDb) ForceMaster() *RoutedDb {
+	r := *routed
+	r.lastsql = Sql{}
+	r.master = true
+	return &r
+}
+
+//NewQuery 生成一个新的查询构造器
+func (routed *RoutedDb) NewQuery() *QueryBuilder {
+	name := routed.configs.contextName(routed.ctx, routed.name)
+	routed.configs.mu.RLock()
+	link := routed.configs.cfg[name]
+	routed.configs.mu.RUnlock()
+	query := newQuery(routed, link, routed.configs)
+	query.tenant, _ = TenantFromContext(routed.ctx)
+	return query
+}
+
+//Begin 在主库开启事务
+func (routed *RoutedDb) Begin() (*QueryTx, error) {
+	db, err := routed.writer()
+	if err != nil {
+		return nil, err
+	}
+	return db.Begin()
+}
+
+//Exec 在主库执行语句
+func (routed *RoutedDb) Exec(query string, args ...interface{}) (sql.Result, error) {
+	db, err := routed.writer()
+	if err != nil {
+		routed.LastSql(query, args...)
+		return nil, err
+	}
+	res, err := db.Exec(query, args...)
+	routed.lastsql = db.GetLastSql()
+	return res, err
+}
+
+//Query 执行查询，不加锁的 SELECT 在从库执行，其它在主库执行
+func (routed *RoutedDb) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	var db *QueryDb
+	var err error
+	if isReadQuery(query) {
+		db, err = routed.reader()
+	} else {
+		db, err = routed.writer()
+	}
+	if err != nil {
+		routed.LastSql(query, args...)
+		return nil, err
+	}
+	rows, err := db.Query(query, args...)
+	routed.lastsql = db.GetLastSql()
+	return rows, err
+}
+
+//GetLastSql 获取sql语句
+func (routed *RoutedDb) GetLastSql() Sql {
+	return routed.lastsql
+}
+
+//LastSql 记录sql语句
+func (routed *RoutedDb) LastSql(query string, args ...interface{}) {
+	routed.lastsql = Sql{Sql: query, Args: args}
+}
+
+//writer 获取主库连接，返回副本以免多个调用方共用 lastsql
+func (routed *RoutedDb) writer() (*QueryDb, error) {
+	return routed.configs.WriteContextE(routed.ctx, routed.name)
+}
+
+//reader 获取从库连接，ForceMaster 或会话中写入后使用主库
+func (routed *RoutedDb) reader() (*QueryDb, error) {
+	if routed.master {
+		return routed.writer()
+	}
+	return routed.configs.ReadContextE(routed.ctx, routed.name)
+}
+
+//isReadQuery 是否为可以在从库执行的查询，SELECT 且不带 FOR UPDATE、LOCK IN SHARE MODE、FOR SHARE
+func isReadQuery(query string) bool {
+	q := strings.ToUpper(strings.TrimLeft(query, " \t\r\n("))
+	if !strings.HasPrefix(q, "SELECT") {
+		return false
+	}
+	for _, lock := range []string{"FOR UPDATE", "LOCK IN SHARE MODE", "FOR SHARE"} {
+		if strings.Contains(q, lock) {
+			return false
+		}
+	}
+	return true
+}
+
+//ForceMaster 当前查询在主库执行，仅对读写分离的连接有效
+func (query *QueryBuilder) ForceMaster() *QueryBuilder {
+	if routed, ok := query.connection.(*RoutedDb); ok {
+		query.connection = routed.ForceMaster()
+	}
+	return query
+}
+
+//UseWrite 同 ForceMaster
+func (query *QueryBuilder) UseWrite() *QueryBuilder {
+	return query.ForceMaster()
+}
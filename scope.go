@@ -0,0 +1,103 @@
+package querydb
+
+//Scope 查询作用域，用于复用条件、排序等片段
+type Scope func(*QueryBuilder) *QueryBuilder
+
+type globalScope struct {
+	name  string
+	scope Scope
+}
+
+//AddGlobalScope 为表注册全局作用域，该表的所有查询都会自动应用
+func (configs *Configs) AddGlobalScope(table string, name string, scope Scope) *Configs {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if configs.scopes == nil {
+		configs.scopes = make(map[string][]globalScope)
+	}
+	scopes := configs.scopes[table]
+	for i := range scopes {
+		if scopes[i].name == name {
+			scopes[i].scope = scope
+			return configs
+		}
+	}
+	configs.scopes[table] = append(scopes, globalScope{name: name, scope: scope})
+	return configs
+}
+
+//RemoveGlobalScope 移除表的全局作用域
+func (configs *Configs) RemoveGlobalScope(table string, name string) *Configs {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	scopes := configs.scopes[table]
+	for i := range scopes {
+		if scopes[i].name == name {
+			configs.scopes[table] = append(scopes[:i:i], scopes[i+1:]...)
+			break
+		}
+	}
+	return configs
+}
+
+//globalScopes 获取表的全局作用域
+func (configs *Configs) globalScopes(table string) []globalScope {
+	configs.mu.RLock()
+	defer configs.mu.RUnlock()
+	return configs.scopes[table]
+}
+
+//AddGlobalScope 为表注册全局作用域，注册在所属的 Configs 上
+func (querydb *QueryDb) AddGlobalScope(table string, name string, scope Scope) *QueryDb {
+	if querydb.configs != nil {
+		querydb.configs.AddGlobalScope(table, name, scope)
+	}
+	return querydb
+}
+
+//Scopes 应用查询作用域
+func (query *QueryBuilder) Scopes(scopes ...Scope) *QueryBuilder {
+	for _, scope := range scopes {
+		query = scope(query)
+	}
+	return query
+}
+
+//WithoutGlobalScope 排除指定名称的全局作用域，不传名称时排除全部
+func (query *QueryBuilder) WithoutGlobalScope(names ...string) *QueryBuilder {
+	if query.without == nil {
+		query.without = make(map[string]bool)
+	}
+	if len(names) == 0 {
+		query.without["*"] = true
+	}
+	for _, name := range names {
+		query.without[name] = true
+	}
+	return query
+}
+
+//applyGlobalScopes 将全局作用域的条件合并到附加条件
+func (query *QueryBuilder) applyGlobalScopes() {
+	if query.configs == nil || len(query.table) < 1 || query.without["*"] {
+		return
+	}
+	name, _ := tableName(query.table[0])
+	for _, item := range query.configs.globalScopes(name) {
+		if query.without[item.name] {
+			continue
+		}
+		sub := &QueryBuilder{connection: query.connection, link: query.link, configs: query.configs, table: query.table, joins: query.joins}
+		sub = item.scope(sub)
+		if sub.argErr != nil && query.argErr == nil {
+			query.argErr = sub.argErr
+		}
+		query.scopeOrder = append(query.scopeOrder, sub.orders...)
+		if len(sub.where) < 1 {
+			continue
+		}
+		//条件整体加括号并以 AND 连接，避免 OR 影响租户、软删除等其它条件
+		query.scopeWhere = append(query.scopeWhere, w{column: "(" + Grammar{builder: sub}.compileConditions(sub.where) + ")", do: AND})
+		query.scopeArgs = append(query.scopeArgs, sub.args...)
+	}
+}
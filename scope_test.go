@@ -0,0 +1,27 @@
+package querydb
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestGlobalScopeIsolatesOrWhere(t *testing.T) {
+	db, server := newFakeDb(t)
+	db.configs.SetTenantColumn("tenant_id", "user")
+	db.AddGlobalScope("user", "active", func(q *QueryBuilder) *QueryBuilder {
+		return q.OrWhere("status", 1)
+	})
+
+	if _, err := db.NewQuery().Table("user").Tenant(7).Where("id", 3).Rows().ToInterface(); err != nil {
+		t.Fatal(err)
+	}
+	calls := server.executed()
+	want := "SELECT * FROM user WHERE tenant_id = ? AND (status = ?) AND (id = ?)"
+	if calls[0].query != want {
+		t.Fatalf("sql = %q, want %q", calls[0].query, want)
+	}
+	if args := calls[0].args; !reflect.DeepEqual(args, []driver.Value{int64(7), int64(1), int64(3)}) {
+		t.Fatalf("args = %v", args)
+	}
+}
@@ -0,0 +1,95 @@
+package querydb
+
+import (
+	"context"
+	"errors"
+)
+
+//ErrClosed 连接已关闭
+var ErrClosed = errors.New("db connection is closed")
+
+//Close 关闭全部连接池并停止从库的健康检查，之后获取连接或使用已获取的连接返回 ErrClosed
+//已开始的查询及事务由 database/sql 在完成后释放，需要等待时使用 Shutdown
+func (configs *Configs) Close() error {
+	var errs []error
+	for _, conn := range configs.shutdown() {
+		conn.active.stop()
+		if err := conn.db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//Shutdown 不再接受新的语句及事务，等待执行中的语句及事务完成后关闭全部连接池
+//ctx 结束时不再等待，关闭连接池并返回 ctx.Err()
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//	err := configs.Shutdown(ctx)
+func (configs *Configs) Shutdown(ctx context.Context) error {
+	conns := configs.shutdown()
+	var errs []error
+	for _, conn := range conns {
+		select {
+		case <-conn.active.stop():
+		case <-ctx.Done():
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, conn := range conns {
+		if err := conn.db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//shutdown 标记为已关闭，移除全部连接并停止从库的健康检查，返回需要关闭的连接
+func (configs *Configs) shutdown() []*QueryDb {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if !configs.closed && configs.done != nil {
+		close(configs.done)
+	}
+	configs.closed = true
+	conns := make([]*QueryDb, 0, len(configs.connections))
+	for _, conn := range configs.connections {
+		conns = append(conns, conn)
+	}
+	for _, pool := range configs.pools {
+		pool.close()
+	}
+	configs.connections = make(map[string]*QueryDb)
+	configs.connecting = make(map[string]*connectCall)
+	configs.pools = nil
+	return conns
+}
+
+//closing 返回 Close 或 Shutdown 时关闭的 channel
+func (configs *Configs) closing() <-chan struct{} {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if configs.done == nil {
+		configs.done = make(chan struct{})
+		if configs.closed {
+			close(configs.done)
+		}
+	}
+	return configs.done
+}
+
+//Close 关闭连接池，之后使用该连接及其副本返回 ErrClosed，已开始的查询及事务不受影响
+//通过 Configs 获取的连接会从 Configs 中移除，再次获取时重新建立
+func (querydb *QueryDb) Close() error {
+	if configs := querydb.configs; configs != nil {
+		configs.mu.Lock()
+		if conn, ok := configs.connections[querydb.name]; ok && conn.db == querydb.db {
+			delete(configs.connections, querydb.name)
+		}
+		configs.mu.Unlock()
+	}
+	querydb.active.stop()
+	return querydb.db.Close()
+}
@@ -0,0 +1,209 @@
+package querydb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//NodeStats 连接池的统计信息
+type NodeStats struct {
+	Name    string        `json:"name"` //连接名称，从库为 {配置名称}_read_{序号}
+	Config  string        `json:"config"`
+	Role    string        `json:"role"` //master 或 replica
+	Addr    string        `json:"addr"`
+	Stats   sql.DBStats   `json:"stats"`
+	Replica *ReplicaStats `json:"replica,omitempty"`
+}
+
+//ReplicaStats 从库的健康状态
+type ReplicaStats struct {
+	InFlight     int64         `json:"in_flight"`     //执行中的语句数
+	Failures     int           `json:"failures"`      //连续失败次数
+	Ejected      bool          `json:"ejected"`       //是否被剔除
+	EjectedUntil time.Time     `json:"ejected_until"` //剔除到期时间
+	Lag          time.Duration `json:"lag"`           //复制延迟，-1 表示未知，未配置 MaxLag 时为 0
+}
+
+//Stats 获取连接池的统计信息
+func (querydb *QueryDb) Stats() sql.DBStats {
+	return querydb.db.Stats()
+}
+
+//Stats 获取已建立的主库及从库连接池的统计信息，按名称排序
+func (configs *Configs) Stats() []NodeStats {
+	configs.mu.RLock()
+	conns := make(map[string]*QueryDb, len(configs.connections))
+	for key, conn := range configs.connections {
+		conns[key] = conn
+	}
+	replicas := make(map[string]*replica)
+	for _, pool := range configs.pools {
+		for _, r := range pool.replicas {
+			replicas[r.key] = r
+		}
+	}
+	configs.mu.RUnlock()
+
+	stats := make([]NodeStats, 0, len(conns))
+	for _, key := range sortedKeys(conns) {
+		conn := conns[key]
+		node := NodeStats{Name: key, Config: key, Role: "master", Addr: conn.link.addr(), Stats: conn.db.Stats()}
+		if r, ok := replicas[key]; ok {
+			node.Config = r.pool.name
+			node.Role = "replica"
+			node.Replica = r.stats()
+		}
+		stats = append(stats, node)
+	}
+	return stats
+}
+
+//stats 从库的健康状态
+func (r *replica) stats() *ReplicaStats {
+	r.mu.Lock()
+	s := &ReplicaStats{
+		InFlight:     atomic.LoadInt64(&r.inFlight),
+		Failures:     r.failures,
+		Ejected:      time.Now().Before(r.until),
+		EjectedUntil: r.until,
+	}
+	r.mu.Unlock()
+	if lag := time.Duration(atomic.LoadInt64(&r.lag)); lag == lagUnknown {
+		s.Lag = -1
+	} else {
+		s.Lag = lag
+	}
+	return s
+}
+
+//HealthReport 健康检查结果
+type HealthReport struct {
+	Healthy bool         `json:"healthy"` //主库都可用，且 NoMasterFallback 的配置至少有一个从库可用
+	Nodes   []NodeHealth `json:"nodes"`
+}
+
+//NodeHealth 单个主库或从库的健康检查结果
+type NodeHealth struct {
+	Name    string        `json:"name"`
+	Config  string        `json:"config"`
+	Role    string        `json:"role"`
+	Addr    string        `json:"addr"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"` //Ping 耗时，纳秒
+	Error   string        `json:"error,omitempty"`
+	Stats   *sql.DBStats  `json:"stats,omitempty"`
+}
+
+//Health 检查全部配置的主库及从库，未建立的连接会先建立，每个节点的检查不超过 timeout
+//租户路由生成的配置不检查
+func (configs *Configs) Health(ctx context.Context, timeout time.Duration) HealthReport {
+	type node struct {
+		name, config, role string
+		link               *Config
+	}
+	configs.mu.RLock()
+	var nodes []node
+	fallback := make(map[string]bool)
+	for _, name := range sortedKeys(configs.cfg) {
+		if strings.Contains(name, "@") {
+			continue
+		}
+		config := configs.cfg[name]
+		nodes = append(nodes, node{name, name, "master", config})
+		for i, slave := range config.Slave {
+			nodes = append(nodes, node{name + "_read_" + strconv.Itoa(i), name, "replica", config.replicaConfig(slave)})
+		}
+		fallback[name] = !config.NoMasterFallback
+	}
+	configs.mu.RUnlock()
+
+	report := HealthReport{Nodes: make([]NodeHealth, len(nodes))}
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n node) {
+			defer wg.Done()
+			report.Nodes[i] = configs.ping(ctx, timeout, n.name, n.link)
+			report.Nodes[i].Config = n.config
+			report.Nodes[i].Role = n.role
+		}(i, n)
+	}
+	wg.Wait()
+
+	report.Healthy = true
+	replicaUp := make(map[string]bool)
+	for _, n := range report.Nodes {
+		switch {
+		case n.Role == "master" && !n.Healthy:
+			report.Healthy = false
+		case n.Role == "replica" && n.Healthy:
+			replicaUp[n.Config] = true
+		}
+	}
+	for name, ok := range fallback {
+		if !ok && !replicaUp[name] {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+//ping 建立连接并 Ping，超时后不再等待建立连接
+func (configs *Configs) ping(ctx context.Context, timeout time.Duration, key string, config *Config) NodeHealth {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	type result struct {
+		stats *sql.DBStats
+		err   error
+	}
+	health := NodeHealth{Name: key, Addr: config.addr()}
+	start := time.Now()
+	done := make(chan result, 1)
+	go func() {
+		conn, err := configs.connection(key, config)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		err = conn.db.PingContext(ctx)
+		stats := conn.db.Stats()
+		done <- result{stats: &stats, err: err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	}
+	health.Latency = time.Since(start)
+	health.Stats = res.stats
+	if res.err != nil {
+		health.Error = res.err.Error()
+	} else {
+		health.Healthy = true
+	}
+	return health
+}
+
+//HealthHandler 以 JSON 输出健康检查结果，健康时返回 200，否则返回 503，可用于就绪探针
+//	http.Handle("/health/db", configs.HealthHandler(2*time.Second))
+func (configs *Configs) HealthHandler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := configs.Health(r.Context(), timeout)
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,338 @@
+package querydb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+//structMapping 字段名映射及按该映射生成的结构体映射缓存，SetNameMapper 时整体替换，
+//替换前开始的解析只写入旧的缓存，不会影响新的映射
+type structMapping struct {
+	mapper func(string) string //没有 db 标签时由成员名生成字段名，为 nil 时忽略没有标签的成员
+	cache  sync.Map            //reflect.Type => *structCacheEntry
+}
+
+var currentMapping atomic.Pointer[structMapping]
+
+func init() {
+	currentMapping.Store(&structMapping{})
+}
+
+//SetNameMapper 设置没有 db 标签的成员的字段名映射，如 SetNameMapper(SnakeCase)，传入 nil 恢复为忽略没有标签的成员
+//可以与查询并发调用，设置后使用新的结构体映射缓存
+func SetNameMapper(mapper func(field string) string) {
+	currentMapping.Store(&structMapping{mapper: mapper})
+}
+
+//SnakeCase 将成员名转换为蛇形命名，如 UserID => user_id，CreatedAt => created_at
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			//前一个是小写或数字，或者处于连续大写的末尾(如 HTTPServer 的 S)时加下划线
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type structCacheEntry struct {
+	info *structInfo
+	err  error
+}
+
+//cachedStructInfo 获取结构体的字段映射，按类型缓存，并发安全
+func cachedStructInfo(typ reflect.Type) (*structInfo, error) {
+	mapping := currentMapping.Load()
+	if entry, ok := mapping.cache.Load(typ); ok {
+		return entry.(*structCacheEntry).info, entry.(*structCacheEntry).err
+	}
+	info, err := newStructInfo(typ, mapping.mapper)
+	entry, _ := mapping.cache.LoadOrStore(typ, &structCacheEntry{info: info, err: err})
+	return entry.(*structCacheEntry).info, entry.(*structCacheEntry).err
+}
+
+//structInfo 结构体字段与查询字段的映射
+type structInfo struct {
+	fields  []structField
+	columns map[string]int      //字段名 => fields 下标
+	groups  []structGroup       //指针类型的嵌套结构体
+	maps    [][]int             //需要初始化的 map 成员
+	autoInc int                 //autoincrement 字段的下标，-1 表示没有
+	mapper  func(string) string //没有 db 标签时的字段名映射
+}
+
+//structField 结构体成员
+type structField struct {
+	name    string //成员名
+	column  string
+	index   []int //reflect.Value.FieldByIndex 的路径
+	options tagOptions
+	group   int //所属的指针结构体，-1 表示不属于
+}
+
+//structGroup 指针类型的嵌套结构体，对应的字段全部为 NULL 时保持 nil
+type structGroup struct {
+	index  []int
+	parent int
+}
+
+//scalarTypes 以下的类型，会再scan的执行转换，所以不需要展开
+var scalarTypes = map[reflect.Type]bool{
+	reflect.TypeOf(time.Time{}):       true,
+	reflect.TypeOf(sql.NullTime{}):    true,
+	reflect.TypeOf(sql.NullString{}):  true,
+	reflect.TypeOf(sql.NullBool{}):    true,
+	reflect.TypeOf(sql.NullInt64{}):   true,
+	reflect.TypeOf(sql.NullInt32{}):   true,
+	reflect.TypeOf(sql.NullFloat64{}): true,
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+//isScalarStruct 是否为不需要展开的结构体
+func isScalarStruct(typ reflect.Type) bool {
+	return scalarTypes[typ] || reflect.PtrTo(typ).Implements(scannerType) || typ.Implements(valuerType)
+}
+
+//newStructInfo 解析结构体的字段映射
+//嵌套结构体的字段默认平铺，`db:"user"` 映射为 user.字段，`db:",prefix=user_"` 映射为 user_字段，匿名嵌入的结构体字段提升到外层
+func newStructInfo(typ reflect.Type, mapper func(string) string) (*structInfo, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("the variable type is %v, not a struct", typ.Kind())
+	}
+	info := &structInfo{columns: make(map[string]int), autoInc: -1, mapper: mapper}
+	if err := info.build(typ, "", nil, -1); err != nil {
+		return nil, err
+	}
+	for i, f := range info.fields {
+		if f.options.autoIncrement && len(f.index) == 1 {
+			info.autoInc = i
+			break
+		}
+	}
+	return info, nil
+}
+
+func (info *structInfo) build(typ reflect.Type, prefix string, index []int, group int) error {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" && (!sf.Anonymous || sf.Type.Kind() == reflect.Ptr) {
+			continue
+		}
+		opts := parseTag(sf.Tag.Get("db"))
+		if opts.ignore {
+			continue
+		}
+		path := make([]int, len(index)+1)
+		copy(path, index)
+		path[len(index)] = i
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && !opts.json && !isScalarStruct(ft) {
+			nested := prefix
+			switch {
+			case opts.prefix != "":
+				nested += opts.prefix
+			case opts.name != "":
+				nested += opts.name + "."
+			}
+			g := group
+			if sf.Type.Kind() == reflect.Ptr {
+				g = len(info.groups)
+				info.groups = append(info.groups, structGroup{index: path, parent: group})
+			}
+			if err := info.build(ft, nested, path, g); err != nil {
+				return err
+			}
+			continue
+		}
+		if sf.Type.Kind() == reflect.Map && !opts.json {
+			info.maps = append(info.maps, path)
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if opts.name == "" {
+			if info.mapper == nil {
+				continue
+			}
+			opts.name = info.mapper(sf.Name)
+		}
+		f := structField{name: sf.Name, column: prefix + opts.name, index: path, options: opts, group: group}
+		if err := info.add(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//add 添加字段，同名字段层级浅的优先，同一层级重复时报错
+func (info *structInfo) add(f structField) error {
+	if i, ok := info.columns[f.column]; ok {
+		exists := info.fields[i]
+		switch {
+		case len(exists.index) < len(f.index):
+			return nil
+		case len(exists.index) == len(f.index):
+			return fmt.Errorf("%s:%s is exists", "db", f.column)
+		}
+		info.fields[i] = f
+		return nil
+	}
+	info.columns[f.column] = len(info.fields)
+	info.fields = append(info.fields, f)
+	return nil
+}
+
+//structScanner 将查询结果的每一行扫描到结构体
+type structScanner struct {
+	info    *structInfo
+	columns []int //查询字段对应的 fields 下标，-1 表示结构体中没有映射
+	valid   []bool
+	opts    decodeOptions
+}
+
+//scanner 根据查询字段生成扫描器
+func (info *structInfo) scanner(columns []string, opts decodeOptions) *structScanner {
+	s := &structScanner{info: info, columns: make([]int, len(columns)), valid: make([]bool, len(info.groups)), opts: opts}
+	for i, column := range columns {
+		s.columns[i] = -1
+		if f, ok := info.columns[column]; ok {
+			s.columns[i] = f
+		}
+	}
+	return s
+}
+
+//check 严格映射检查，查询字段必须有对应成员，成员必须有对应字段，skip 为不检查的查询字段下标
+func (s *structScanner) check(typ reflect.Type, columns []string, skip int) error {
+	mapped := make([]bool, len(s.info.fields))
+	var unmapped []string
+	for i, fi := range s.columns {
+		if fi >= 0 {
+			mapped[fi] = true
+		} else if i != skip {
+			unmapped = append(unmapped, columns[i])
+		}
+	}
+	if len(unmapped) > 0 {
+		return fmt.Errorf("strict: columns %s have no destination in %v", strings.Join(unmapped, ", "), typ)
+	}
+	var missing []string
+	for i, f := range s.info.fields {
+		if !mapped[i] {
+			missing = append(missing, f.name+"("+f.column+")")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("strict: fields %s of %v have no column in result", strings.Join(missing, ", "), typ)
+	}
+	return nil
+}
+
+//dest 获取 Scan 的目标，v 为可设置的结构体
+func (s *structScanner) dest(v reflect.Value) []interface{} {
+	for _, path := range s.info.maps {
+		if m := v.FieldByIndex(path); m.IsNil() {
+			m.Set(reflect.MakeMap(m.Type()))
+		}
+	}
+	//指针结构体先初始化，扫描后根据是否有非 NULL 字段决定是否保留
+	for i, g := range s.info.groups {
+		s.valid[i] = false
+		if p := v.FieldByIndex(g.index); p.IsNil() {
+			p.Set(reflect.New(p.Type().Elem()))
+		}
+	}
+	refs := make([]interface{}, len(s.columns))
+	for i, fi := range s.columns {
+		if fi < 0 {
+			refs[i] = new(interface{})
+			continue
+		}
+		f := s.info.fields[fi]
+		value := v.FieldByIndex(f.index)
+		switch {
+		case f.group >= 0:
+			refs[i] = &nullScanner{value: value, json: f.options.json, dest: s.opts.scanDest(value), valid: &s.valid[f.group]}
+		case f.options.json:
+			refs[i] = &jsonScanner{value: value}
+		default:
+			refs[i] = s.opts.scanDest(value)
+		}
+	}
+	return refs
+}
+
+//finish 扫描后将字段全部为 NULL 的指针结构体置为 nil
+func (s *structScanner) finish(v reflect.Value) {
+	groups := s.info.groups
+	for i := len(groups) - 1; i >= 0; i-- {
+		if s.valid[i] && groups[i].parent >= 0 {
+			s.valid[groups[i].parent] = true
+		}
+	}
+	for i, g := range groups {
+		if !s.valid[i] && (g.parent < 0 || s.valid[g.parent]) {
+			p := v.FieldByIndex(g.index)
+			p.Set(reflect.Zero(p.Type()))
+		}
+	}
+}
+
+//nullScanner 指针结构体的成员，NULL 时保持零值并记录是否有值
+type nullScanner struct {
+	value reflect.Value
+	json  bool
+	dest  interface{} //非 JSON 字段扫描的目标
+	valid *bool
+}
+
+//Scan 实现 sql.Scanner
+func (s *nullScanner) Scan(src interface{}) error {
+	if src == nil {
+		return nil
+	}
+	*s.valid = true
+	if s.json {
+		return (&jsonScanner{value: s.value}).Scan(src)
+	}
+	if scanner, ok := s.dest.(sql.Scanner); ok {
+		return scanner.Scan(src)
+	}
+	return convertAssign(s.value, src)
+}
+
+//fieldByIndex 按路径获取成员，路径中有 nil 指针时返回 false
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,113 @@
+package querydb
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+type benchUser struct {
+	Id        int64     `db:"id,pk,autoincrement"`
+	Name      string    `db:"name"`
+	Email     string    `db:"email"`
+	Age       int       `db:"age"`
+	Status    int       `db:"status,zero"`
+	CreatedAt time.Time `db:"created_at"`
+	Profile   struct {
+		City    string `db:"city"`
+		Country string `db:"country"`
+	} `db:",prefix=profile_"`
+}
+
+//clearStructCache 清空结构体映射缓存，用于对比不使用缓存时的开销
+func clearStructCache() {
+	SetNameMapper(currentMapping.Load().mapper)
+}
+
+func BenchmarkToStruct(b *testing.B) {
+	db, server := newFakeDb(b)
+	columns := []string{"id", "name", "email", "age", "status", "created_at", "profile_city", "profile_country"}
+	var rows [][]driver.Value
+	for i := 0; i < 10; i++ {
+		rows = append(rows, []driver.Value{int64(i), "name", "a@b.c", int64(20), int64(1), time.Now(), "city", "country"})
+	}
+	server.result("FROM user", columns, rows...)
+
+	run := func(b *testing.B, cached bool) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !cached {
+				clearStructCache()
+			}
+			var users []benchUser
+			if err := db.NewQuery().Table("user").Rows().ToStruct(&users); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("cached", func(b *testing.B) { run(b, true) })
+	b.Run("uncached", func(b *testing.B) { run(b, false) })
+}
+
+func BenchmarkGetInsertMap(b *testing.B) {
+	query := newQuery(nil, &Config{}, Default())
+	user := benchUser{Name: "name", Email: "a@b.c", Age: 20, CreatedAt: time.Now()}
+
+	run := func(b *testing.B, cached bool) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if !cached {
+				clearStructCache()
+			}
+			if _, _, err := query.getInsertMap(user, writeInsert); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("cached", func(b *testing.B) { run(b, true) })
+	b.Run("uncached", func(b *testing.B) { run(b, false) })
+}
+
+type mappedUser struct {
+	ID       int64
+	UserName string
+}
+
+func TestSetNameMapperConcurrent(t *testing.T) {
+	defer SetNameMapper(nil)
+	db, server := newFakeDb(t)
+	server.result("FROM user", []string{"id", "user_name"}, []driver.Value{int64(1), "a"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetNameMapper(SnakeCase)
+			SetNameMapper(nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		var users []mappedUser
+		if err := db.NewQuery().Table("user").Rows().ToStruct(&users); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	SetNameMapper(SnakeCase)
+	var user mappedUser
+	if err := db.NewQuery().Table("user").Row().ToStruct(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user != (mappedUser{1, "a"}) {
+		t.Fatalf("user = %+v", user)
+	}
+	SetNameMapper(nil)
+	user = mappedUser{}
+	if err := db.NewQuery().Table("user").Row().ToStruct(&user); err != nil {
+		t.Fatal(err)
+	}
+	if user != (mappedUser{}) {
+		t.Fatalf("user = %+v, want fields without tag ignored", user)
+	}
+}
@@ -0,0 +1,150 @@
+package querydb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//写入方式
+const (
+	writeInsert = iota
+	writeUpdate
+)
+
+//tagOptions db 标签选项，如 `db:"created_at,autoCreateTime"`
+type tagOptions struct {
+	name           string //字段名
+	ignore         bool   //- 忽略字段
+	pk             bool   //pk 主键，更新时不写入
+	autoIncrement  bool   //autoincrement 自增，零值不写入，插入后回写ID
+	autoCreateTime bool   //autoCreateTime 插入时零值自动写入当前时间，更新时不写入
+	autoUpdateTime bool   //autoUpdateTime 插入、更新时自动写入当前时间
+	json           bool   //json 以 JSON 格式读写
+	readonly       bool   //readonly 只读，不写入
+	zero           bool   //zero 零值也写入
+	prefix         string //prefix=user_ 嵌套结构体的字段前缀
+}
+
+//parseTag 解析 db 标签
+func parseTag(tag string) tagOptions {
+	attrs := strings.Split(tag, ",")
+	opts := tagOptions{name: strings.TrimSpace(attrs[0])}
+	if opts.name == "-" {
+		opts.ignore = true
+	}
+	for _, attr := range attrs[1:] {
+		attr = strings.TrimSpace(attr)
+		if strings.HasPrefix(strings.ToLower(attr), "prefix=") {
+			opts.prefix = attr[len("prefix="):]
+			continue
+		}
+		switch strings.ToLower(attr) {
+		case "-":
+			opts.ignore = true
+		case "pk":
+			opts.pk = true
+		case "autoincrement":
+			opts.autoIncrement = true
+		case "autocreatetime":
+			opts.autoCreateTime = true
+		case "autoupdatetime":
+			opts.autoUpdateTime = true
+		case "json":
+			opts.json = true
+		case "readonly":
+			opts.readonly = true
+		case "zero":
+			opts.zero = true
+		}
+	}
+	return opts
+}
+
+//jsonScanner 将 JSON 字段解析到结构体成员
+type jsonScanner struct {
+	value reflect.Value
+}
+
+//Scan 实现 sql.Scanner
+func (s *jsonScanner) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		s.value.Set(reflect.Zero(s.value.Type()))
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot unmarshal %T into json field", src)
+	}
+	ptr := reflect.New(s.value.Type())
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return err
+	}
+	s.value.Set(ptr.Elem())
+	return nil
+}
+
+//nowValue 按字段类型生成当前时间，支持 time.Time、sql.NullTime、整数(时间戳)和字符串
+func nowValue(typ reflect.Type) (reflect.Value, bool) {
+	now := time.Now()
+	ptr := typ.Kind() == reflect.Ptr
+	if ptr {
+		typ = typ.Elem()
+	}
+	var v reflect.Value
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		v = reflect.ValueOf(now.Unix()).Convert(typ)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		v = reflect.ValueOf(uint64(now.Unix())).Convert(typ)
+	case reflect.String:
+		v = reflect.ValueOf(now.Format("2006-01-02 15:04:05")).Convert(typ)
+	case reflect.Struct:
+		switch typ {
+		case reflect.TypeOf(now):
+			v = reflect.ValueOf(now)
+		case reflect.TypeOf(sql.NullTime{}):
+			v = reflect.ValueOf(sql.NullTime{Time: now, Valid: true})
+		default:
+			return v, false
+		}
+	default:
+		return v, false
+	}
+	if ptr {
+		p := reflect.New(typ)
+		p.Elem().Set(v)
+		return p, true
+	}
+	return v, true
+}
+
+//setAutoIncrement 插入后将自增ID回写到结构体的 autoincrement 字段
+func setAutoIncrement(data interface{}, id int64) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	info, err := cachedStructInfo(v.Elem().Type())
+	if err != nil || info.autoInc < 0 {
+		return
+	}
+	f := v.Elem().FieldByIndex(info.fields[info.autoInc].index)
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.Int() == 0 {
+			f.SetInt(id)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f.Uint() == 0 {
+			f.SetUint(uint64(id))
+		}
+	}
+}
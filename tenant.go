@@ -0,0 +1,283 @@
+package querydb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//ErrNoTenant 多租户表缺少租户
+var ErrNoTenant = errors.New("tenant-scoped table queried without tenant")
+
+//TenantRouter 租户路由，根据配置名称和租户返回使用的配置名称及数据库名称，返回空字符串时沿用原值
+type TenantRouter func(name string, tenant interface{}) (config string, database string)
+
+//tenancy 多租户配置
+type tenancy struct {
+	column string          //租户字段
+	tables map[string]bool //按租户字段隔离的表
+	router TenantRouter
+}
+
+type tenantKey struct{}
+
+//WithTenant 在 context 中携带租户
+func WithTenant(ctx context.Context, tenant interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+//TenantFromContext 获取 context 中携带的租户
+func TenantFromContext(ctx context.Context) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+//SetTenantColumn 设置租户字段及按该字段隔离的表，查询、更新、删除自动追加 column = 租户，插入自动写入租户
+func (configs *Configs) SetTenantColumn(column string, tables ...string) *Configs {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	configs.tenancy.column = column
+	if configs.tenancy.tables == nil {
+		configs.tenancy.tables = make(map[string]bool)
+	}
+	for _, table := range tables {
+		configs.tenancy.tables[table] = true
+	}
+	return configs
+}
+
+//SetTenantRouter 设置租户路由，用于每个租户独立的连接或数据库
+func (configs *Configs) SetTenantRouter(router TenantRouter) *Configs {
+	configs.mu.Lock()
+	configs.tenancy.router = router
+	configs.mu.Unlock()
+	return configs
+}
+
+//TenantWrite 获取租户的主库连接，找不到配置或连接失败时 panic，需要处理错误时使用 TenantWriteE
+func (configs *Configs) TenantWrite(name string, tenant interface{}) *QueryDb {
+	return mustConnect(configs.TenantWriteE(name, tenant))
+}
+
+//TenantWriteE 获取租户的主库连接，找不到配置时返回 ErrConfigNotFound
+func (configs *Configs) TenantWriteE(name string, tenant interface{}) (*QueryDb, error) {
+	db, err := configs.WriteE(configs.tenantRoute(name, tenant))
+	if err != nil {
+		return nil, err
+	}
+	return db.Tenant(tenant), nil
+}
+
+//TenantRead 获取租户的从库连接，找不到配置或连接失败时 panic，需要处理错误时使用 TenantReadE
+func (configs *Configs) TenantRead(name string, tenant interface{}) *QueryDb {
+	return mustConnect(configs.TenantReadE(name, tenant))
+}
+
+//TenantReadE 获取租户的从库连接，找不到配置时返回 ErrConfigNotFound
+func (configs *Configs) TenantReadE(name string, tenant interface{}) (*QueryDb, error) {
+	db, err := configs.ReadE(configs.tenantRoute(name, tenant))
+	if err != nil {
+		return nil, err
+	}
+	return db.Tenant(tenant), nil
+}
+
+//WriteContext 获取绑定 context 的主库连接，context 中携带租户时按租户路由
+//找不到配置或连接失败时 panic，需要处理错误时使用 WriteContextE
+func (configs *Configs) WriteContext(ctx context.Context, name string) *QueryDb {
+	return mustConnect(configs.WriteContextE(ctx, name))
+}
+
+//WriteContextE 获取绑定 context 的主库连接，找不到配置时返回 ErrConfigNotFound
+func (configs *Configs) WriteContextE(ctx context.Context, name string) (*QueryDb, error) {
+	db, err := configs.WriteE(configs.contextName(ctx, name))
+	if err != nil {
+		return nil, err
+	}
+	return db.WithContext(ctx), nil
+}
+
+//ReadContext 获取绑定 context 的从库连接，context 中携带租户时按租户路由
+//context 中的会话在 ReadYourWrites 时间内写入过时返回主库
+//找不到配置或连接失败时 panic，需要处理错误时使用 ReadContextE
+func (configs *Configs) ReadContext(ctx context.Context, name string) *QueryDb {
+	return mustConnect(configs.ReadContextE(ctx, name))
+}
+
+//ReadContextE 获取绑定 context 的从库连接，找不到配置时返回 ErrConfigNotFound
+func (configs *Configs) ReadContextE(ctx context.Context, name string) (*QueryDb, error) {
+	name = configs.contextName(ctx, name)
+	read := configs.ReadE
+	if configs.pinned(ctx, name) {
+		read = configs.WriteE
+	}
+	db, err := read(name)
+	if err != nil {
+		return nil, err
+	}
+	return db.WithContext(ctx), nil
+}
+
+//contextName 获取 context 对应的配置名称，context 中携带租户时按租户路由
+func (configs *Configs) contextName(ctx context.Context, name string) string {
+	if tenant, ok := TenantFromContext(ctx); ok {
+		return configs.tenantRoute(name, tenant)
+	}
+	return name
+}
+
+//tenantRoute 根据租户路由获取配置名称，路由返回数据库名称时复制配置并替换数据库
+func (configs *Configs) tenantRoute(name string, tenant interface{}) string {
+	configs.mu.RLock()
+	router := configs.tenancy.router
+	configs.mu.RUnlock()
+	if router == nil {
+		return name
+	}
+	config, database := router(name, tenant)
+	if config == "" {
+		config = name
+	}
+	if database == "" {
+		return config
+	}
+	key := config + "@" + database
+
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if _, ok := configs.cfg[key]; !ok {
+		base, ok := configs.cfg[config]
+		if !ok {
+			return config
+		}
+		configs.cfg[key] = base.withDatabase(database)
+	}
+	return key
+}
+
+//withDatabase 复制配置并替换数据库名称
+func (config *Config) withDatabase(database string) *Config {
+	c := *config
+	c.Database = database
+	c.Slave = nil
+	for _, slave := range config.Slave {
+		c.Slave = append(c.Slave, slave.withDatabase(database))
+	}
+	return &c
+}
+
+//Tenant 返回绑定租户的连接
+func (querydb *QueryDb) Tenant(tenant interface{}) *QueryDb {
+	q := *querydb
+	q.lastsql = Sql{}
+	q.tenant = tenant
+	return &q
+}
+
+//Tenant 设置当前查询的租户
+func (query *QueryBuilder) Tenant(tenant interface{}) *QueryBuilder {
+	query.tenant = tenant
+	return query
+}
+
+//WithoutTenant 当前查询不按租户隔离
+func (query *QueryBuilder) WithoutTenant() *QueryBuilder {
+	query.noTenant = true
+	return query
+}
+
+//tenantColumn 获取当前表的租户字段，表未按租户隔离时返回空字符串
+func (query *QueryBuilder) tenantColumn() string {
+	if len(query.table) < 1 {
+		return ""
+	}
+	return query.tableTenantColumn(query.table[0])
+}
+
+//tableTenantColumn 获取表的租户字段，表未按租户隔离或当前查询不按租户隔离时返回空字符串
+func (query *QueryBuilder) tableTenantColumn(table string) string {
+	if query.configs == nil || query.noTenant {
+		return ""
+	}
+	name, _ := tableName(table)
+	query.configs.mu.RLock()
+	defer query.configs.mu.RUnlock()
+	if !query.configs.tenancy.tables[name] {
+		return ""
+	}
+	return query.configs.tenancy.column
+}
+
+//applyTenant 为按租户隔离的表追加租户条件，包括 Table 的多个表及 JOIN 的表
+//多表时字段加表别名，JOIN 的表追加到 ON 中，避免 LEFT JOIN 变为 INNER JOIN
+func (query *QueryBuilder) applyTenant() error {
+	var tenant interface{}
+	encoded := false
+	value := func(table string) (interface{}, error) {
+		if encoded {
+			return tenant, nil
+		}
+		if query.tenant == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoTenant, table)
+		}
+		var err error
+		tenant, err = query.configs.encode(query.tenant)
+		encoded = err == nil
+		return tenant, err
+	}
+
+	qualify := len(query.table) > 1 || len(query.joins) > 0
+	for _, table := range query.table {
+		column := query.tableTenantColumn(table)
+		if column == "" {
+			continue
+		}
+		v, err := value(table)
+		if err != nil {
+			return err
+		}
+		if qualify {
+			_, alias := tableName(table)
+			column = alias + "." + column
+		}
+		query.scopeWhere = append(query.scopeWhere, w{column: column, operator: EQUAL, valuenum: 1, do: AND})
+		query.scopeArgs = append(query.scopeArgs, v)
+	}
+	for i := range query.joins {
+		query.joins[i].tenant = ""
+		column := query.tableTenantColumn(query.joins[i].table)
+		if column == "" {
+			continue
+		}
+		v, err := value(query.joins[i].table)
+		if err != nil {
+			return err
+		}
+		_, alias := tableName(query.joins[i].table)
+		query.joins[i].tenant = alias + "." + column
+		query.joinArgs = append(query.joinArgs, v)
+	}
+	return nil
+}
+
+//fillTenant 为插入的数据写入租户
+func (query *QueryBuilder) fillTenant(rows ...map[string]interface{}) error {
+	column := query.tenantColumn()
+	if column == "" {
+		return nil
+	}
+	if query.tenant == nil {
+		return fmt.Errorf("%w: %s", ErrNoTenant, query.table[0])
+	}
+	tenant, err := query.configs.encode(query.tenant)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		row[column] = tenant
+	}
+	return nil
+}
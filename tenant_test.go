@@ -0,0 +1,131 @@
+package querydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTenantUnionBranches(t *testing.T) {
+	db, server := newFakeDb(t)
+	db.configs.SetTenantColumn("tenant_id", "orders")
+
+	branch := db.NewQuery().Table("orders").Where("id", 2)
+	_, err := db.NewQuery().Table("orders").Tenant(1).Where("id", 1).Union(*branch).Rows().ToInterface()
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := server.executed()
+	want := "(SELECT * FROM orders WHERE tenant_id = ? AND (id = ?)) UNION (SELECT * FROM orders WHERE tenant_id = ? AND (id = ?))"
+	if calls[0].query != want {
+		t.Fatalf("sql = %q, want %q", calls[0].query, want)
+	}
+	if args := calls[0].args; !reflect.DeepEqual(args, []driver.Value{int64(1), int64(1), int64(1), int64(2)}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	branch = db.NewQuery().Table("orders").Where("id", 2)
+	_, err = db.NewQuery().Table("orders").WithoutTenant().Union(*branch).Rows().ToInterface()
+	if !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("err = %v, want ErrNoTenant", err)
+	}
+}
+
+func TestTenantJoinedTables(t *testing.T) {
+	db, server := newFakeDb(t)
+	db.configs.SetTenantColumn("tenant_id", "orders", "items")
+
+	_, err := db.NewQuery().Table("users u").LeftJoin("orders o", "o.user_id = u.id").Tenant(5).Where("u.id", 1).Rows().ToInterface()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.NewQuery().Table("orders o", "items i").Tenant(5).Where("o.id = i.order_id").Rows().ToInterface()
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := server.executed()
+	want := []string{
+		"SELECT * FROM users u LEFT JOIN orders o ON (o.user_id = u.id) AND o.tenant_id = ? WHERE u.id = ?",
+		"SELECT * FROM orders o,items i WHERE o.tenant_id = ? AND i.tenant_id = ? AND (o.id = i.order_id)",
+	}
+	for i, call := range calls {
+		if call.query != want[i] {
+			t.Errorf("sql = %q, want %q", call.query, want[i])
+		}
+	}
+	if args := calls[0].args; !reflect.DeepEqual(args, []driver.Value{int64(5), int64(1)}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	_, err = db.NewQuery().Table("users u").Join("orders o", "o.user_id = u.id").Rows().ToInterface()
+	if !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("err = %v, want ErrNoTenant", err)
+	}
+}
+
+func TestTenantAccessorsReturnErrors(t *testing.T) {
+	configs := Default()
+	ctx := WithTenant(context.Background(), 1)
+	accessors := map[string]func() (*QueryDb, error){
+		"TenantWriteE":  func() (*QueryDb, error) { return configs.TenantWriteE("missing", 1) },
+		"TenantReadE":   func() (*QueryDb, error) { return configs.TenantReadE("missing", 1) },
+		"WriteContextE": func() (*QueryDb, error) { return configs.WriteContextE(ctx, "missing") },
+		"ReadContextE":  func() (*QueryDb, error) { return configs.ReadContextE(ctx, "missing") },
+	}
+	for name, accessor := range accessors {
+		if _, err := accessor(); !errors.Is(err, ErrConfigNotFound) {
+			t.Errorf("%s err = %v, want ErrConfigNotFound", name, err)
+		}
+	}
+}
+
+func TestRouterNewQueryWithTenantDatabase(t *testing.T) {
+	configs := Default()
+	configs.SetConfig("default", &Config{Database: "shop"})
+	configs.SetTenantRouter(func(name string, tenant interface{}) (string, string) {
+		return "", fmt.Sprintf("shop_%v", tenant)
+	})
+
+	done := make(chan *QueryBuilder, 1)
+	go func() {
+		done <- configs.Router("default").WithContext(WithTenant(context.Background(), 7)).NewQuery()
+	}()
+	select {
+	case query := <-done:
+		if query.link == nil || query.link.Database != "shop_7" {
+			t.Fatalf("link = %+v, want database shop_7", query.link)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("NewQuery did not return")
+	}
+}
+
+func TestSetConfigRegeneratesTenantConfigs(t *testing.T) {
+	configs := Default()
+	defer configs.Close()
+	configs.SetConfig("default", &Config{Username: "u", Password: "old", Host: "10.0.0.1", Lazy: true})
+	configs.SetTenantRouter(func(name string, tenant interface{}) (string, string) {
+		return "", fmt.Sprintf("t%v", tenant)
+	})
+
+	db, err := configs.TenantWriteE("default", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.link.Password != "old" {
+		t.Fatalf("password = %q", db.link.Password)
+	}
+
+	configs.SetConfig("default", &Config{Username: "u", Password: "new", Host: "10.0.0.1", Lazy: true})
+	db, err = configs.TenantWriteE("default", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.link.Password != "new" || db.link.Database != "t1" {
+		t.Fatalf("tenant config = %+v, want the new password and database t1", *db.link)
+	}
+}